@@ -1,8 +1,13 @@
 package poetryinstall
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/paketo-buildpacks/packit/v2"
@@ -10,12 +15,17 @@ import (
 	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
 )
 
+//go:generate faux --interface BindingResolver --output fakes/binding_resolver.go
+//go:generate faux --interface DebugSymbolStripper --output fakes/debug_symbol_stripper.go
 //go:generate faux --interface EntryResolver --output fakes/entry_resolver.go
 //go:generate faux --interface InstallProcess --output fakes/install_process.go
 //go:generate faux --interface PythonPathLookupProcess --output fakes/python_path_process.go
+//go:generate faux --interface PythonVersionLookupProcess --output fakes/python_version_process.go
 //go:generate faux --interface SBOMGenerator --output fakes/sbom_generator.go
+//go:generate faux --interface VenvNormalizer --output fakes/venv_normalizer.go
 
 // EntryResolver defines the interface for picking the most relevant entry from
 // the Buildpack Plan entries.
@@ -23,10 +33,15 @@ type EntryResolver interface {
 	MergeLayerTypes(name string, entries []packit.BuildpackPlanEntry) (launch, build bool)
 }
 
+// BindingResolver defines the interface for resolving service bindings.
+type BindingResolver interface {
+	Resolve(typ, provider, platformDir string) ([]servicebindings.Binding, error)
+}
+
 // InstallProcess defines the interface for installing the poetry dependencies.
 // It returns the location of the virtual env directory.
 type InstallProcess interface {
-	Execute(workingDir, targetDir, cacheDir string) (string, error)
+	Execute(workingDir, targetDir, cacheDir string, extraGroups []string, bindings []servicebindings.Binding) (string, error)
 }
 
 // PythonPathProcess defines the interface for finding the PYTHONPATH (AKA the site-packages directory)
@@ -34,16 +49,42 @@ type PythonPathLookupProcess interface {
 	Execute(venvDir string) (string, error)
 }
 
+// PythonVersionLookupProcess defines the interface for finding the version of
+// the python interpreter the virtual env is built against.
+type PythonVersionLookupProcess interface {
+	Execute() (string, error)
+}
+
+// SBOMGenerator defines the interface for generating the SBOM of the packages
+// installed into the site-packages directory of the virtual env.
 type SBOMGenerator interface {
-	Generate(dir string) (sbom.SBOM, error)
+	Generate(workingDir, sitePackagesDir string) (sbom.SBOM, error)
+}
+
+// VenvNormalizer defines the interface for removing the differences between
+// two installs of the same packages into a virtual env.
+type VenvNormalizer interface {
+	Normalize(workingDir, venvDir string) error
+}
+
+// DebugSymbolStripper defines the interface for stripping the debug symbols
+// from the native extension modules in the site-packages directories of a
+// virtual env.
+type DebugSymbolStripper interface {
+	Strip(pythonPath string, strict bool) (StripResult, error)
 }
 
 // Build will return a packit.BuildFunc that will be invoked during the build
 // phase of the buildpack lifecycle.
 //
 // Build will install the poetry dependencies by using the pyproject.toml file
-// to a virtual environment layer.
-func Build(entryResolver EntryResolver, installProcess InstallProcess, pythonPathProcess PythonPathLookupProcess, sbomGenerator SBOMGenerator, clock chronos.Clock, logger scribe.Emitter) packit.BuildFunc {
+// to a virtual environment layer. The layer is reused as-is on subsequent
+// builds when poetry.lock, pyproject.toml, the install settings and the
+// CPython version are unchanged. A newly installed virtual environment is
+// normalized, and optionally pruned and stripped of debug symbols, before its
+// console scripts are added as launch processes. Credentials, certificates
+// and mirrors of the package sources are read from service bindings.
+func Build(entryResolver EntryResolver, installProcess InstallProcess, venvNormalizer VenvNormalizer, pythonPathProcess PythonPathLookupProcess, debugSymbolStripper DebugSymbolStripper, pythonVersionProcess PythonVersionLookupProcess, sbomGenerator SBOMGenerator, bindingResolver BindingResolver, clock chronos.Clock, logger scribe.Emitter) packit.BuildFunc {
 	return func(context packit.BuildContext) (packit.BuildResult, error) {
 		logger.Title("%s %s", context.BuildpackInfo.Name, context.BuildpackInfo.Version)
 
@@ -57,33 +98,284 @@ func Build(entryResolver EntryResolver, installProcess InstallProcess, pythonPat
 			return packit.BuildResult{}, err
 		}
 
-		var venvDir string
-		logger.Process("Executing build process")
-		duration, err := clock.Measure(func() error {
-			venvDir, err = installProcess.Execute(context.WorkingDir, venvLayer.Path, cacheLayer.Path)
-			return err
-		})
+		cpythonVersion, err := pythonVersionProcess.Execute()
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
-		logger.Action("Completed in %s", duration.Round(time.Millisecond))
-		logger.Break()
+		groups, err := loadInstallGroups()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		extras, allExtras, err := installExtras()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		root, rootSet, err := installRoot()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		compile, err := compileBytecode()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		inProject, err := virtualenvsInProject(context.WorkingDir)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		prune, prunePatterns, err := venvPrune()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		strip, err := stripDebugSymbols()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		lockPolicy, err := loadLockPolicy()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		strict, err := strictVerification()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		var bindings []servicebindings.Binding
+		for _, bindingType := range slices.Concat(SourceCredentialBindingTypes, CertificateBindingTypes, SourceMirrorBindingTypes) {
+			resolved, err := bindingResolver.Resolve(bindingType, "", context.Platform.Path)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+			bindings = append(bindings, resolved...)
+		}
+
+		metadata, err := newVenvMetadata(context.WorkingDir)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if metadata.PoetryLockSHA == "" {
+			required, err := requireLock()
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			if required {
+				return packit.BuildResult{}, errors.New("no 'poetry.lock' found, but BP_POETRY_REQUIRE_LOCK is set: run 'poetry lock' and commit poetry.lock")
+			}
+		}
+
+		metadata.InstallExtras = strings.Join(extras, ",")
+		if allExtras {
+			metadata.InstallExtras = ":all:"
+		}
+		if rootSet {
+			metadata.InstallRoot = strconv.FormatBool(root)
+		}
+		metadata.PoetryConfig = poetryConfigFingerprint()
+		if compile {
+			metadata.CompileBytecode = "true"
+		}
+		metadata.CPythonVersion = cpythonVersion
+		metadata.LockPolicy = lockPolicy
+
+		installVenv := func(layer packit.Layer, layerMetadata venvMetadata, extraGroups []string, title string) (packit.Layer, string, bool, error) {
+			layerMetadata.InstallGroups = groups.including(extraGroups).String()
+
+			var venvDir string
+			reuse, reason := layerMetadata.compare(layer.Metadata)
+			if reuse {
+				venvDir = layer.Metadata["venv_dir"].(string)
+
+				logger.Process("Reusing cached layer %s", layer.Path)
+				logger.Subprocess(reason)
+				logger.Break()
+			} else {
+				logger.Process(title)
+				logger.Subprocess("Rebuilding layer: %s", reason)
+
+				// Poetry does not restore the files that were pruned from the
+				// cached virtual env, or the debug symbols that were stripped
+				// from it, so that it is installed anew.
+				pruned, _ := layer.Metadata["venv_prune"].(string)
+				stripped, _ := layer.Metadata["strip_debug_symbols"].(string)
+				if pruned != "" || stripped != "" {
+					logger.Subprocess("Removing the cached virtual env, as it was pruned or stripped of debug symbols")
+
+					var err error
+					layer, err = layer.Reset()
+					if err != nil {
+						return packit.Layer{}, "", false, err
+					}
+				}
+
+				duration, err := clock.Measure(func() error {
+					venvDir, err = installProcess.Execute(context.WorkingDir, layer.Path, cacheLayer.Path, extraGroups, bindings)
+					if err != nil {
+						return err
+					}
+
+					return venvNormalizer.Normalize(context.WorkingDir, venvDir)
+				})
+				if err != nil {
+					return packit.Layer{}, "", false, err
+				}
+
+				logger.Action("Completed in %s", duration.Round(time.Millisecond))
+				logger.Break()
+			}
+
+			layerMetadata.VenvDir = venvDir
+			layer.Metadata = layerMetadata.toMap()
+
+			return layer, venvDir, reuse, nil
+		}
+
+		cachedPrunedPaths, hasCachedPrunedPaths := venvLayer.Metadata["pruned_paths"]
+
+		// Pruning and stripping only apply to the poetry-venv layer, and not to
+		// the layer of the build-time groups.
+		venvLayerMetadata := metadata
+		venvLayerMetadata.VenvPrune = strings.Join(prunePatterns, ",")
+		if strip {
+			venvLayerMetadata.StripDebugSymbols = "true"
+			if strict {
+				venvLayerMetadata.StripDebugSymbols = "strict"
+			}
+		}
+
+		venvLayer, venvDir, reused, err := installVenv(venvLayer, venvLayerMetadata, nil, "Executing build process")
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if metadata.PoetryLockSHA == "" {
+			generatedLock, err := os.ReadFile(filepath.Join(context.WorkingDir, "poetry.lock"))
+			if err != nil && !os.IsNotExist(err) {
+				return packit.BuildResult{}, err
+			}
+
+			if err == nil {
+				venvLayer.Metadata["generated_poetry_lock"] = string(generatedLock)
+				logger.Process("Saved the generated poetry.lock in the metadata of the %s layer", VenvLayerName)
+				logger.Subprocess("Commit it to the app to make the build reproducible")
+				logger.Break()
+			}
+		}
+
+		if inProject {
+			err = linkInProjectVenv(context.WorkingDir, venvDir)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			logger.Process("Linking %s to the virtual env", filepath.Join(context.WorkingDir, ".venv"))
+			logger.Subprocess("The app asks for an in-project virtual env")
+			logger.Break()
+		}
 
 		pythonPathDir, err := pythonPathProcess.Execute(venvDir)
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
+		if strip && !reused {
+			logger.Process("Stripping debug symbols from native extension modules")
+
+			var result StripResult
+			duration, err := clock.Measure(func() error {
+				result, err = debugSymbolStripper.Strip(pythonPathDir, strict)
+				return err
+			})
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			logger.Subprocess("Stripped %d modules: %s -> %s", result.Stripped, formatSize(result.SizeBefore), formatSize(result.SizeAfter))
+			if result.Skipped > 0 {
+				logger.Subprocess("Skipped %d modules whose hashes are recorded in RECORD (BP_POETRY_STRICT_VERIFICATION)", result.Skipped)
+			}
+			logger.Action("Completed in %s", duration.Round(time.Millisecond))
+			logger.Break()
+		}
+
+		switch {
+		case prune && reused:
+			if hasCachedPrunedPaths {
+				venvLayer.Metadata["pruned_paths"] = cachedPrunedPaths
+			}
+
+		case prune:
+			prunedPaths, saved, err := pruneVenv(venvDir, prunePatterns, cpythonVersion)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+			venvLayer.Metadata["pruned_paths"] = prunedPaths
+
+			logger.Process("Pruning the virtual env")
+			logger.Subprocess("Removed %d paths matching [%s] or compiled for another Python version", len(prunedPaths), strings.Join(prunePatterns, ", "))
+			logger.Subprocess("Saved %s", formatSize(saved))
+			logger.Break()
+		}
+
 		venvLayer.Launch, venvLayer.Build = entryResolver.MergeLayerTypes(PoetryVenv, context.Plan.Entries)
 		venvLayer.Cache = venvLayer.Launch || venvLayer.Build
 		cacheLayer.Cache = true
 
+		var processes []packit.Process
+		if venvLayer.Launch {
+			err = relocateVenv(venvDir, venvLayer.Path, filepath.Dir(context.Layers.Path), logger)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			processes, err = launchProcesses(context.WorkingDir, venvDir)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+		}
+
+		var buildVenvLayer packit.Layer
+		buildGroups := loadBuildGroups()
+		if len(buildGroups) > 0 {
+			buildVenvLayer, err = context.Layers.Get(BuildVenvLayerName)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			var buildVenvDir string
+			buildVenvLayer, buildVenvDir, _, err = installVenv(buildVenvLayer, metadata, buildGroups, fmt.Sprintf("Executing build process for build-time groups [%s]", strings.Join(buildGroups, ", ")))
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			buildPythonPathDir, err := pythonPathProcess.Execute(buildVenvDir)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			buildVenvLayer.Build = true
+			buildVenvLayer.Cache = true
+
+			buildVenvLayer.BuildEnv.Override("POETRY_VIRTUALENVS_PATH", buildVenvLayer.Path)
+			buildVenvLayer.BuildEnv.Override("VIRTUAL_ENV", buildVenvDir)
+			buildVenvLayer.BuildEnv.Prepend("PYTHONPATH", buildPythonPathDir, string(os.PathListSeparator))
+			buildVenvLayer.BuildEnv.Prepend("PATH", filepath.Join(buildVenvDir, "bin"), string(os.PathListSeparator))
+		}
+
 		logger.GeneratingSBOM(venvLayer.Path)
 
 		var sbomContent sbom.SBOM
-		duration, err = clock.Measure(func() error {
-			sbomContent, err = sbomGenerator.Generate(context.WorkingDir)
+		duration, err := clock.Measure(func() error {
+			sbomContent, err = sbomGenerator.Generate(context.WorkingDir, pythonPathDir)
 			return err
 		})
 		if err != nil {
@@ -100,12 +392,18 @@ func Build(entryResolver EntryResolver, installProcess InstallProcess, pythonPat
 		}
 
 		venvLayer.SharedEnv.Default("POETRY_VIRTUALENVS_PATH", venvLayer.Path)
+		venvLayer.SharedEnv.Default("VIRTUAL_ENV", venvDir)
 		venvLayer.SharedEnv.Prepend("PYTHONPATH", pythonPathDir, string(os.PathListSeparator))
 		venvLayer.SharedEnv.Prepend("PATH", filepath.Join(venvDir, "bin"), string(os.PathListSeparator))
 
 		logger.EnvironmentVariables(venvLayer)
 
 		layers := []packit.Layer{venvLayer}
+		if len(buildGroups) > 0 {
+			logger.EnvironmentVariables(buildVenvLayer)
+			layers = append(layers, buildVenvLayer)
+		}
+
 		if _, err := os.Stat(cacheLayer.Path); err == nil {
 			if !fs.IsEmptyDir(cacheLayer.Path) {
 				layers = append(layers, cacheLayer)
@@ -116,6 +414,11 @@ func Build(entryResolver EntryResolver, installProcess InstallProcess, pythonPat
 			Layers: layers,
 		}
 
+		if len(processes) > 0 {
+			logger.LaunchProcesses(processes)
+			result.Launch.Processes = processes
+		}
+
 		return result, nil
 	}
 }
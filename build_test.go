@@ -7,16 +7,21 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
 	poetryinstall "github.com/paketo-buildpacks/poetry-install"
 	"github.com/paketo-buildpacks/poetry-install/fakes"
 	"github.com/sclevine/spec"
 
 	. "github.com/onsi/gomega"
+	. "github.com/paketo-buildpacks/occam/matchers"
 )
 
 func testBuild(t *testing.T, context spec.G, it spec.S) {
@@ -27,10 +32,14 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		workingDir string
 		cnbDir     string
 
-		entryResolver     *fakes.EntryResolver
-		installProcess    *fakes.InstallProcess
-		sbomGenerator     *fakes.SBOMGenerator
-		pythonPathProcess *fakes.PythonPathLookupProcess
+		bindingResolver      *fakes.BindingResolver
+		entryResolver        *fakes.EntryResolver
+		installProcess       *fakes.InstallProcess
+		venvNormalizer       *fakes.VenvNormalizer
+		sbomGenerator        *fakes.SBOMGenerator
+		pythonPathProcess    *fakes.PythonPathLookupProcess
+		debugSymbolStripper  *fakes.DebugSymbolStripper
+		pythonVersionProcess *fakes.PythonVersionLookupProcess
 
 		buffer *bytes.Buffer
 
@@ -52,21 +61,34 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		installProcess = &fakes.InstallProcess{}
 		installProcess.ExecuteCall.Returns.String = "some-venv-dir"
 
+		venvNormalizer = &fakes.VenvNormalizer{}
+
 		pythonPathProcess = &fakes.PythonPathLookupProcess{}
 		pythonPathProcess.ExecuteCall.Returns.String = "some-python-path"
 
+		debugSymbolStripper = &fakes.DebugSymbolStripper{}
+
+		pythonVersionProcess = &fakes.PythonVersionLookupProcess{}
+		pythonVersionProcess.ExecuteCall.Returns.String = "3.12.4"
+
 		entryResolver = &fakes.EntryResolver{}
 
 		sbomGenerator = &fakes.SBOMGenerator{}
 		sbomGenerator.GenerateCall.Returns.SBOM = sbom.SBOM{}
 
+		bindingResolver = &fakes.BindingResolver{}
+
 		buffer = bytes.NewBuffer(nil)
 
 		build = poetryinstall.Build(
 			entryResolver,
 			installProcess,
+			venvNormalizer,
 			pythonPathProcess,
+			debugSymbolStripper,
+			pythonVersionProcess,
 			sbomGenerator,
+			bindingResolver,
 			chronos.DefaultClock,
 			scribe.NewEmitter(buffer),
 		)
@@ -115,12 +137,13 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		Expect(venvLayer.LaunchEnv).To(BeEmpty())
 		Expect(venvLayer.ProcessLaunchEnv).To(BeEmpty())
 
-		Expect(venvLayer.SharedEnv).To(HaveLen(5))
+		Expect(venvLayer.SharedEnv).To(HaveLen(6))
 		Expect(venvLayer.SharedEnv["PATH.prepend"]).To(Equal("some-venv-dir/bin"))
 		Expect(venvLayer.SharedEnv["PATH.delim"]).To(Equal(":"))
 		Expect(venvLayer.SharedEnv["PYTHONPATH.prepend"]).To(Equal("some-python-path"))
 		Expect(venvLayer.SharedEnv["PYTHONPATH.delim"]).To(Equal(":"))
 		Expect(venvLayer.SharedEnv["POETRY_VIRTUALENVS_PATH.default"]).To(Equal(filepath.Join(layersDir, "poetry-venv")))
+		Expect(venvLayer.SharedEnv["VIRTUAL_ENV.default"]).To(Equal("some-venv-dir"))
 
 		Expect(venvLayer.SBOM.Formats()).To(HaveLen(2))
 		var actualExtensions []string
@@ -132,18 +155,328 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		Expect(installProcess.ExecuteCall.Receives.WorkingDir).To(Equal(workingDir))
 		Expect(installProcess.ExecuteCall.Receives.TargetDir).To(Equal(filepath.Join(layersDir, "poetry-venv")))
 		Expect(installProcess.ExecuteCall.Receives.CacheDir).To(Equal(filepath.Join(layersDir, "cache")))
+		Expect(installProcess.ExecuteCall.Receives.ExtraGroups).To(BeNil())
+		Expect(installProcess.ExecuteCall.Receives.Bindings).To(BeEmpty())
+
+		Expect(venvNormalizer.NormalizeCall.Receives.WorkingDir).To(Equal(workingDir))
+		Expect(venvNormalizer.NormalizeCall.Receives.VenvDir).To(Equal("some-venv-dir"))
+
+		Expect(bindingResolver.ResolveCall.CallCount).To(Equal(5))
+		Expect(bindingResolver.ResolveCall.Receives.PlatformDir).To(Equal("some-platform-path"))
 
 		Expect(pythonPathProcess.ExecuteCall.Receives.VenvDir).To(Equal("some-venv-dir"))
 
-		Expect(sbomGenerator.GenerateCall.Receives.Dir).To(Equal(workingDir))
+		Expect(sbomGenerator.GenerateCall.Receives.WorkingDir).To(Equal(workingDir))
+		Expect(sbomGenerator.GenerateCall.Receives.SitePackagesDir).To(Equal("some-python-path"))
 
 		Expect(entryResolver.MergeLayerTypesCall.Receives.Name).To(Equal("poetry-venv"))
 		Expect(entryResolver.MergeLayerTypesCall.Receives.Entries).To(Equal([]packit.BuildpackPlanEntry{
 			{Name: "poetry-venv"},
 		}))
 
+		Expect(venvLayer.Metadata).To(Equal(map[string]interface{}{
+			"poetry_lock_sha":     "",
+			"pyproject_toml_sha":  "",
+			"install_groups":      "main",
+			"install_extras":      "",
+			"install_root":        "",
+			"poetry_config":       "",
+			"compile_bytecode":    "",
+			"cpython_version":     "3.12.4",
+			"venv_prune":          "",
+			"strip_debug_symbols": "",
+			"lock_policy":         "strict",
+			"venv_dir":            "some-venv-dir",
+		}))
+
 		Expect(buffer.String()).To(ContainSubstring("Some Buildpack some-version"))
 		Expect(buffer.String()).To(ContainSubstring("Executing build process"))
+		Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: no cached layer metadata found"))
+	})
+
+	context("when the venv layer was cached by a previous build", func() {
+		var (
+			venvDir        string
+			cachedMetadata map[string]interface{}
+		)
+
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte("[tool.poetry]"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("# poetry.lock"), 0600)).To(Succeed())
+
+			venvDir = filepath.Join(layersDir, "poetry-venv", "some-venv-dir")
+			Expect(os.MkdirAll(venvDir, os.ModePerm)).To(Succeed())
+
+			poetryLockSHA, err := fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "poetry.lock"))
+			Expect(err).NotTo(HaveOccurred())
+
+			pyProjectSHA, err := fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "pyproject.toml"))
+			Expect(err).NotTo(HaveOccurred())
+
+			cachedMetadata = map[string]interface{}{
+				"poetry_lock_sha":     poetryLockSHA,
+				"pyproject_toml_sha":  pyProjectSHA,
+				"install_groups":      "main",
+				"install_extras":      "",
+				"install_root":        "",
+				"poetry_config":       "",
+				"compile_bytecode":    "",
+				"cpython_version":     "3.12.4",
+				"venv_prune":          "",
+				"strip_debug_symbols": "",
+				"lock_policy":         "strict",
+				"venv_dir":            venvDir,
+			}
+
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+		})
+
+		writeLayerMetadata := func() {
+			content, err := toml.Marshal(map[string]interface{}{
+				"types":    map[string]bool{"launch": true, "cache": true},
+				"metadata": cachedMetadata,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(layersDir, "poetry-venv.toml"), content, 0600)).To(Succeed())
+		}
+
+		it("reuses the cached layer when nothing has changed", func() {
+			writeLayerMetadata()
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(0))
+			Expect(venvNormalizer.NormalizeCall.CallCount).To(Equal(0))
+			Expect(pythonPathProcess.ExecuteCall.Receives.VenvDir).To(Equal(venvDir))
+
+			venvLayer := result.Layers[0]
+			Expect(venvLayer.Launch).To(BeTrue())
+			Expect(venvLayer.Cache).To(BeTrue())
+			Expect(venvLayer.Metadata).To(Equal(cachedMetadata))
+			Expect(venvLayer.SharedEnv["PATH.prepend"]).To(Equal(filepath.Join(venvDir, "bin")))
+
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Reusing cached layer %s", filepath.Join(layersDir, "poetry-venv"))))
+			Expect(buffer.String()).To(ContainSubstring("poetry.lock, pyproject.toml, install settings and CPython version are unchanged"))
+			Expect(buffer.String()).NotTo(ContainSubstring("Executing build process"))
+		})
+
+		it("rebuilds the layer when poetry.lock has changed", func() {
+			cachedMetadata["poetry_lock_sha"] = "some-other-sha"
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: poetry.lock changed"))
+		})
+
+		it("rebuilds the layer when pyproject.toml has changed", func() {
+			cachedMetadata["pyproject_toml_sha"] = "some-other-sha"
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: pyproject.toml changed"))
+		})
+
+		it("rebuilds the layer when the install groups have changed", func() {
+			cachedMetadata["install_groups"] = "main,dev"
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: install groups changed from 'main,dev' to 'main'"))
+		})
+
+		context("when the install extras have changed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_INSTALL_ALL_EXTRAS", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_ALL_EXTRAS")).To(Succeed())
+			})
+
+			it("rebuilds the layer", func() {
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: install extras changed from '' to ':all:'"))
+			})
+		})
+
+		context("when the root project installation has changed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_INSTALL_ROOT", "false")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_ROOT")).To(Succeed())
+			})
+
+			it("rebuilds the layer", func() {
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: root project installation changed from '' to 'false'"))
+			})
+		})
+
+		context("when the poetry configuration has changed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_CONFIG_INSTALLER_NO_BINARY", ":all:")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_CONFIG_INSTALLER_NO_BINARY")).To(Succeed())
+			})
+
+			it("rebuilds the layer", func() {
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: poetry configuration changed from '' to 'installer.no-binary=:all:'"))
+			})
+		})
+
+		context("when bytecode compilation has been enabled", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_COMPILE_BYTECODE", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_COMPILE_BYTECODE")).To(Succeed())
+			})
+
+			it("rebuilds the layer", func() {
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: bytecode compilation changed from '' to 'true'"))
+			})
+		})
+
+		context("when BP_POETRY_INSTALL_WITH and BP_POETRY_INSTALL_WITHOUT have changed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_INSTALL_WITH", "monitoring")).To(Succeed())
+				Expect(os.Setenv("BP_POETRY_INSTALL_WITHOUT", "docs")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_WITH")).To(Succeed())
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_WITHOUT")).To(Succeed())
+			})
+
+			it("rebuilds the layer with the added and excluded groups", func() {
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: install groups changed from 'main' to 'main,monitoring,-docs'"))
+			})
+		})
+
+		context("when BP_POETRY_LOCK_POLICY has changed", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_LOCK_POLICY", "strict")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_LOCK_POLICY")).To(Succeed())
+			})
+
+			it("rebuilds the layer so that poetry.lock is checked under the new policy", func() {
+				cachedMetadata["lock_policy"] = "warn"
+				writeLayerMetadata()
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: lock policy changed from 'warn' to 'strict'"))
+			})
+		})
+
+		it("rebuilds the layer when the CPython version has changed", func() {
+			cachedMetadata["cpython_version"] = "3.11.9"
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: CPython version changed from '3.11.9' to '3.12.4'"))
+		})
+
+		it("rebuilds the layer when the cached virtual env is missing", func() {
+			Expect(os.RemoveAll(venvDir)).To(Succeed())
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: cached virtual environment not found"))
+		})
+
+		it("rebuilds the layer when there is no poetry.lock", func() {
+			Expect(os.Remove(filepath.Join(workingDir, "poetry.lock"))).To(Succeed())
+			writeLayerMetadata()
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: no 'poetry.lock' found"))
+		})
+	})
+
+	context("when the project has no poetry.lock", func() {
+		it.Before(func() {
+			installProcess.ExecuteCall.Stub = func(workingDir, _, _ string, _ []string, _ []servicebindings.Binding) (string, error) {
+				return "some-venv-dir", os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("# generated poetry.lock\n"), 0600)
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_REQUIRE_LOCK")).To(Succeed())
+		})
+
+		it("saves the generated poetry.lock in the layer metadata", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("generated_poetry_lock", "# generated poetry.lock\n"))
+			Expect(buffer.String()).To(ContainSubstring("Saved the generated poetry.lock in the metadata of the poetry-venv layer"))
+		})
+
+		it("returns an error when BP_POETRY_REQUIRE_LOCK is set", func() {
+			Expect(os.Setenv("BP_POETRY_REQUIRE_LOCK", "true")).To(Succeed())
+
+			_, err := build(buildContext)
+			Expect(err).To(MatchError("no 'poetry.lock' found, but BP_POETRY_REQUIRE_LOCK is set: run 'poetry lock' and commit poetry.lock"))
+			Expect(installProcess.ExecuteCall.CallCount).To(Equal(0))
+		})
 	})
 
 	context("poetry-venv is required at build and launch", func() {
@@ -192,92 +525,1001 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(venvLayer.LaunchEnv).To(BeEmpty())
 			Expect(venvLayer.ProcessLaunchEnv).To(BeEmpty())
 
-			Expect(venvLayer.SharedEnv).To(HaveLen(5))
+			Expect(venvLayer.SharedEnv).To(HaveLen(6))
 			Expect(venvLayer.SharedEnv["PATH.prepend"]).To(Equal("some-venv-dir/bin"))
 			Expect(venvLayer.SharedEnv["PATH.delim"]).To(Equal(":"))
 			Expect(venvLayer.SharedEnv["PYTHONPATH.prepend"]).To(Equal("some-python-path"))
 			Expect(venvLayer.SharedEnv["PYTHONPATH.delim"]).To(Equal(":"))
 			Expect(venvLayer.SharedEnv["POETRY_VIRTUALENVS_PATH.default"]).To(Equal(filepath.Join(layersDir, "poetry-venv")))
+			Expect(venvLayer.SharedEnv["VIRTUAL_ENV.default"]).To(Equal("some-venv-dir"))
 		})
 	})
 
-	context("install process utilizes cache", func() {
-		it.Before(func() {
-			installProcess.ExecuteCall.Stub = func(_, _, cachePath string) (string, error) {
-				err := os.MkdirAll(filepath.Join(cachePath, "something"), os.ModePerm)
-				if err != nil {
-					return "", fmt.Errorf("issue with stub call: %+v", err)
-				}
+	context("when service bindings provide source credentials, certificates and mirrors", func() {
+		var resolvedTypes []string
 
-				return "some-cached-venv-dir", nil
+		it.Before(func() {
+			resolvedTypes = nil
+			bindingResolver.ResolveCall.Stub = func(typ, _, _ string) ([]servicebindings.Binding, error) {
+				resolvedTypes = append(resolvedTypes, typ)
+				return []servicebindings.Binding{{Name: fmt.Sprintf("some-%s-binding", typ), Type: typ}}, nil
 			}
+		})
+
+		it("passes the bindings to the install process", func() {
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolvedTypes).To(Equal([]string{"poetry", "pypi-repository", "ca-certificates", "poetry-certs", "poetry-mirror"}))
+			Expect(installProcess.ExecuteCall.Receives.Bindings).To(Equal([]servicebindings.Binding{
+				{Name: "some-poetry-binding", Type: "poetry"},
+				{Name: "some-pypi-repository-binding", Type: "pypi-repository"},
+				{Name: "some-ca-certificates-binding", Type: "ca-certificates"},
+				{Name: "some-poetry-certs-binding", Type: "poetry-certs"},
+				{Name: "some-poetry-mirror-binding", Type: "poetry-mirror"},
+			}))
+		})
+	})
+
+	context("when the project declares console scripts", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(`
+[tool.poetry]
+name = "some-app"
+
+[tool.poetry.scripts]
+some-command = "some_app.cli:main"
+
+[project.scripts]
+other-command = "some_app.cli:other"
+`), 0600)).To(Succeed())
+
 			entryResolver.MergeLayerTypesCall.Returns.Launch = true
-			entryResolver.MergeLayerTypesCall.Returns.Build = true
 		})
 
-		it("result should include a cache layer", func() {
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_DEFAULT_PROCESS")).To(Succeed())
+			Expect(os.Unsetenv("BP_POETRY_GENERATE_PROCESSES")).To(Succeed())
+			Expect(os.Unsetenv("BP_POETRY_INSTALL_ROOT")).To(Succeed())
+		})
+
+		it("adds a launch process for each script", func() {
 			result, err := build(buildContext)
 			Expect(err).NotTo(HaveOccurred())
 
-			layers := result.Layers
-			Expect(layers).To(HaveLen(2))
+			Expect(result.Launch.Processes).To(Equal([]packit.Process{
+				{
+					Type:    "other-command",
+					Command: filepath.Join("some-venv-dir", "bin", "other-command"),
+					Args:    []string{},
+					Direct:  true,
+				},
+				{
+					Type:    "some-command",
+					Command: filepath.Join("some-venv-dir", "bin", "some-command"),
+					Args:    []string{},
+					Direct:  true,
+				},
+			}))
 
-			venvLayer := layers[0]
-			Expect(venvLayer.Name).To(Equal("poetry-venv"))
+			Expect(buffer.String()).To(ContainSubstring("Assigning launch processes:"))
+		})
 
-			Expect(venvLayer.Build).To(BeTrue())
-			Expect(venvLayer.Launch).To(BeTrue())
-			Expect(venvLayer.Cache).To(BeTrue())
+		it("makes the process named by BP_POETRY_DEFAULT_PROCESS the default", func() {
+			Expect(os.Setenv("BP_POETRY_DEFAULT_PROCESS", "some-command")).To(Succeed())
 
-			Expect(venvLayer.BuildEnv).To(BeEmpty())
-			Expect(venvLayer.LaunchEnv).To(BeEmpty())
-			Expect(venvLayer.ProcessLaunchEnv).To(BeEmpty())
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
 
-			Expect(venvLayer.SharedEnv).To(HaveLen(5))
-			Expect(venvLayer.SharedEnv["PATH.prepend"]).To(Equal("some-cached-venv-dir/bin"))
-			Expect(venvLayer.SharedEnv["PATH.delim"]).To(Equal(":"))
-			Expect(venvLayer.SharedEnv["PYTHONPATH.prepend"]).To(Equal("some-python-path"))
-			Expect(venvLayer.SharedEnv["PYTHONPATH.delim"]).To(Equal(":"))
-			Expect(venvLayer.SharedEnv["POETRY_VIRTUALENVS_PATH.default"]).To(Equal(filepath.Join(layersDir, "poetry-venv")))
+			Expect(result.Launch.Processes).To(HaveLen(2))
+			Expect(result.Launch.Processes[0].Default).To(BeFalse())
+			Expect(result.Launch.Processes[1].Default).To(BeTrue())
+		})
 
-			cacheLayer := layers[1]
-			Expect(cacheLayer.Name).To(Equal("cache"))
-			Expect(cacheLayer.Path).To(Equal(filepath.Join(layersDir, "cache")))
-			Expect(cacheLayer.Build).To(BeFalse())
-			Expect(cacheLayer.Launch).To(BeFalse())
-			Expect(cacheLayer.Cache).To(BeTrue())
-			Expect(cacheLayer.BuildEnv).To(BeEmpty())
-			Expect(cacheLayer.LaunchEnv).To(BeEmpty())
-			Expect(cacheLayer.ProcessLaunchEnv).To(BeEmpty())
-			Expect(cacheLayer.SharedEnv).To(BeEmpty())
-			Expect(cacheLayer.Metadata).To(BeEmpty())
+		it("does not add processes when BP_POETRY_GENERATE_PROCESSES is false", func() {
+			Expect(os.Setenv("BP_POETRY_GENERATE_PROCESSES", "false")).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Launch.Processes).To(BeEmpty())
 		})
-	})
 
-	context("failure cases", func() {
-		context("when the layers directory cannot be written to", func() {
+		it("does not add processes when the root project is not installed", func() {
+			Expect(os.Setenv("BP_POETRY_INSTALL_ROOT", "false")).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Launch.Processes).To(BeEmpty())
+		})
+
+		context("when only one script is declared", func() {
 			it.Before(func() {
-				Expect(os.Chmod(layersDir, 0000)).To(Succeed())
-			})
+				Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(`
+[project]
+name = "some-app"
 
-			it.After(func() {
-				Expect(os.Chmod(layersDir, os.ModePerm)).To(Succeed())
+[project.scripts]
+some-command = "some_app.cli:main"
+`), 0600)).To(Succeed())
 			})
 
-			it("returns an error", func() {
-				_, err := build(buildContext)
-				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			it("makes it the default process", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Processes).To(Equal([]packit.Process{
+					{
+						Type:    "some-command",
+						Command: filepath.Join("some-venv-dir", "bin", "some-command"),
+						Args:    []string{},
+						Direct:  true,
+						Default: true,
+					},
+				}))
 			})
 		})
 
-		context("when install process returns an error", func() {
+		context("when the venv is not required at launch", func() {
 			it.Before(func() {
-				installProcess.ExecuteCall.Returns.Error = errors.New("could not run install process")
+				entryResolver.MergeLayerTypesCall.Returns.Launch = false
+				entryResolver.MergeLayerTypesCall.Returns.Build = true
 			})
 
-			it("returns an error", func() {
+			it("does not add processes", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Launch.Processes).To(BeEmpty())
+			})
+		})
+
+		context("failure cases", func() {
+			it("returns an error when BP_POETRY_DEFAULT_PROCESS is not a declared script", func() {
+				Expect(os.Setenv("BP_POETRY_DEFAULT_PROCESS", "web")).To(Succeed())
+
 				_, err := build(buildContext)
-				Expect(err).To(MatchError("could not run install process"))
+				Expect(err).To(MatchError("process 'web' from BP_POETRY_DEFAULT_PROCESS is not declared in pyproject.toml, declared scripts are: [other-command, some-command]"))
+			})
+
+			it("returns an error when BP_POETRY_GENERATE_PROCESSES is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_GENERATE_PROCESSES", "some-value")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_GENERATE_PROCESSES value 'some-value'")))
+			})
+		})
+	})
+
+	context("when the virtual env refers to paths that are not available at launch", func() {
+		var (
+			venvDir         string
+			launchPythonDir string
+			buildPythonDir  string
+		)
+
+		it.Before(func() {
+			buildContext.Layers.Path = filepath.Join(layersDir, "some-buildpack")
+
+			launchPythonDir = filepath.Join(layersDir, "some-cpython-buildpack", "cpython", "bin")
+			Expect(os.MkdirAll(launchPythonDir, os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(launchPythonDir, "python3.12"), nil, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "some-cpython-buildpack", "cpython.toml"), []byte("[types]\nlaunch = true\n"), 0600)).To(Succeed())
+
+			buildPythonDir = filepath.Join(layersDir, "some-poetry-buildpack", "poetry", "bin")
+			Expect(os.MkdirAll(buildPythonDir, os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildPythonDir, "python3.12"), nil, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "some-poetry-buildpack", "poetry.toml"), []byte("[types]\nbuild = true\n"), 0600)).To(Succeed())
+
+			venvDir = filepath.Join(layersDir, "some-buildpack", "poetry-venv", "some-venv-dir")
+			Expect(os.MkdirAll(filepath.Join(venvDir, "bin"), os.ModePerm)).To(Succeed())
+			Expect(os.Symlink(filepath.Join(buildPythonDir, "python3.12"), filepath.Join(venvDir, "bin", "python"))).To(Succeed())
+			Expect(os.Symlink("python", filepath.Join(venvDir, "bin", "python3"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(venvDir, "pyvenv.cfg"), []byte(fmt.Sprintf("home = %s\nimplementation = CPython\nversion_info = 3.12.4.final.0\n", buildPythonDir)), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(venvDir, "bin", "some-command"), []byte(fmt.Sprintf("#!%s -E\nimport sys\n", filepath.Join(buildPythonDir, "python3.12"))), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(venvDir, "bin", "other-command"), []byte("#!/usr/bin/env python\nimport sys\n"), 0755)).To(Succeed())
+
+			installProcess.ExecuteCall.Returns.String = venvDir
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+		})
+
+		it("rewrites them to paths that are available at launch", func() {
+			timestamp := time.Unix(315532801, 0)
+			Expect(os.Chtimes(filepath.Join(venvDir, "pyvenv.cfg"), timestamp, timestamp)).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].SharedEnv["VIRTUAL_ENV.default"]).To(Equal(venvDir))
+
+			content, err := os.ReadFile(filepath.Join(venvDir, "pyvenv.cfg"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(fmt.Sprintf("home = %s\nimplementation = CPython\nversion_info = 3.12.4.final.0\n", launchPythonDir)))
+
+			info, err := os.Stat(filepath.Join(venvDir, "pyvenv.cfg"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ModTime()).To(Equal(timestamp))
+
+			Expect(os.Readlink(filepath.Join(venvDir, "bin", "python"))).To(Equal(filepath.Join(launchPythonDir, "python3.12")))
+			Expect(os.Readlink(filepath.Join(venvDir, "bin", "python3"))).To(Equal("python"))
+
+			content, err = os.ReadFile(filepath.Join(venvDir, "bin", "some-command"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(fmt.Sprintf("#!%s -E\nimport sys\n", filepath.Join(venvDir, "bin", "python"))))
+
+			content, err = os.ReadFile(filepath.Join(venvDir, "bin", "other-command"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("#!/usr/bin/env python\nimport sys\n"))
+
+			Expect(buffer.String()).To(ContainLines(
+				"  Relocating the virtual env, as it refers to paths that are not available at launch",
+				fmt.Sprintf("    pyvenv.cfg: home %s -> %s", buildPythonDir, launchPythonDir),
+				fmt.Sprintf("    bin/python: %s -> %s", filepath.Join(buildPythonDir, "python3.12"), filepath.Join(launchPythonDir, "python3.12")),
+				fmt.Sprintf("    bin/some-command: %s -> %s", filepath.Join(buildPythonDir, "python3.12"), filepath.Join(venvDir, "bin", "python")),
+			))
+		})
+
+		it("does not rewrite the virtual env when it is not required at launch", func() {
+			entryResolver.MergeLayerTypesCall.Returns.Launch = false
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := os.ReadFile(filepath.Join(venvDir, "pyvenv.cfg"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring(fmt.Sprintf("home = %s", buildPythonDir)))
+
+			Expect(buffer.String()).NotTo(ContainSubstring("Relocating the virtual env"))
+		})
+
+		context("when there is no python of the same version available at launch", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(layersDir, "some-cpython-buildpack", "cpython.toml"), []byte("[types]\nbuild = true\n"), 0600)).To(Succeed())
+			})
+
+			it("logs a warning", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(venvDir, "pyvenv.cfg"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring(fmt.Sprintf("home = %s", buildPythonDir)))
+
+				Expect(buffer.String()).To(ContainLines(
+					fmt.Sprintf("  Warning: the python interpreter of the virtual env in '%s' is not available at launch", buildPythonDir),
+					"    Require cpython at launch for the virtual env to work in the app image",
+				))
+			})
+		})
+	})
+
+	context("when the app asks for an in-project virtual env", func() {
+		var venvDir string
+
+		it.Before(func() {
+			venvDir = filepath.Join(layersDir, "poetry-venv", "some-venv-dir")
+			installProcess.ExecuteCall.Returns.String = venvDir
+
+			Expect(os.WriteFile(filepath.Join(workingDir, "poetry.toml"), []byte("[virtualenvs]\nin-project = true\n"), 0600)).To(Succeed())
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_VIRTUALENVS_IN_PROJECT")).To(Succeed())
+		})
+
+		it("links the .venv directory of the app to the virtual env", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.Readlink(filepath.Join(workingDir, ".venv"))).To(Equal(venvDir))
+			Expect(result.Layers[0].SharedEnv["VIRTUAL_ENV.default"]).To(Equal(venvDir))
+
+			Expect(buffer.String()).To(ContainLines(
+				fmt.Sprintf("  Linking %s to the virtual env", filepath.Join(workingDir, ".venv")),
+				"    The app asks for an in-project virtual env",
+			))
+		})
+
+		it("replaces a .venv link from a previous build", func() {
+			Expect(os.Symlink("some-old-venv-dir", filepath.Join(workingDir, ".venv"))).To(Succeed())
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.Readlink(filepath.Join(workingDir, ".venv"))).To(Equal(venvDir))
+		})
+
+		it("links the .venv directory when BP_POETRY_VIRTUALENVS_IN_PROJECT is set", func() {
+			Expect(os.Remove(filepath.Join(workingDir, "poetry.toml"))).To(Succeed())
+			Expect(os.Setenv("BP_POETRY_VIRTUALENVS_IN_PROJECT", "true")).To(Succeed())
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.Readlink(filepath.Join(workingDir, ".venv"))).To(Equal(venvDir))
+		})
+
+		it("does not link the .venv directory when BP_POETRY_VIRTUALENVS_IN_PROJECT is false", func() {
+			Expect(os.Setenv("BP_POETRY_VIRTUALENVS_IN_PROJECT", "false")).To(Succeed())
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(workingDir, ".venv")).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).NotTo(ContainSubstring("Linking"))
+		})
+
+		context("failure cases", func() {
+			it("returns an error when the app has a .venv directory", func() {
+				Expect(os.Mkdir(filepath.Join(workingDir, ".venv"), os.ModePerm)).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(fmt.Sprintf("cannot link '%s' to the virtual env, as it already exists in the app: remove it from the app, or set BP_POETRY_VIRTUALENVS_IN_PROJECT to false", filepath.Join(workingDir, ".venv"))))
+			})
+
+			it("returns an error when BP_POETRY_VIRTUALENVS_IN_PROJECT is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_VIRTUALENVS_IN_PROJECT", "yes please")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_VIRTUALENVS_IN_PROJECT value 'yes please'")))
+			})
+
+			it("returns an error when poetry.toml is malformed", func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.toml"), []byte("%%%"), 0600)).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse poetry.toml")))
+			})
+		})
+	})
+
+	context("when the virtual env is pruned", func() {
+		var (
+			venvDir         string
+			sitePackagesDir string
+		)
+
+		it.Before(func() {
+			Expect(os.Setenv("BP_POETRY_VENV_PRUNE", "true")).To(Succeed())
+
+			venvDir = filepath.Join(layersDir, "poetry-venv", "some-venv-dir")
+			sitePackagesDir = filepath.Join(venvDir, "lib", "python3.12", "site-packages")
+			Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "flask", "tests"), os.ModePerm)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "flask", "__pycache__"), os.ModePerm)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info"), os.ModePerm)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(venvDir, "include", "site", "python3.12", "greenlet"), os.ModePerm)).To(Succeed())
+
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "__init__.py"), []byte("import os\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "tests", "test_app.py"), []byte("def test_app(): pass\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc"), []byte("some-bytecode"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-311.pyc"), []byte("some-old-bytecode"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info", "METADATA"), []byte("Name: flask\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info", "RECORD"), []byte("flask/__init__.py,,\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(venvDir, "include", "site", "python3.12", "greenlet", "greenlet.h"), []byte("#define GREENLET\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "libspeedups.a"), []byte("!<arch>\n"), 0644)).To(Succeed())
+
+			installProcess.ExecuteCall.Returns.String = venvDir
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_VENV_PRUNE")).To(Succeed())
+			Expect(os.Unsetenv("BP_POETRY_VENV_PRUNE_PATTERNS")).To(Succeed())
+		})
+
+		it("removes the files that are not needed at launch and lists them in the layer metadata", func() {
+			timestamp := time.Unix(315532801, 0)
+			Expect(os.Chtimes(filepath.Join(sitePackagesDir, "flask"), timestamp, timestamp)).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(sitePackagesDir, "flask", "__init__.py")).To(BeAnExistingFile())
+			Expect(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc")).To(BeAnExistingFile())
+			Expect(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info", "METADATA")).To(BeAnExistingFile())
+
+			Expect(filepath.Join(sitePackagesDir, "flask", "tests")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-311.pyc")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info", "RECORD")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(venvDir, "include", "site", "python3.12", "greenlet", "greenlet.h")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(sitePackagesDir, "flask", "libspeedups.a")).NotTo(BeAnExistingFile())
+
+			info, err := os.Stat(filepath.Join(sitePackagesDir, "flask"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ModTime()).To(Equal(timestamp))
+
+			venvLayer := result.Layers[0]
+			Expect(venvLayer.Metadata["venv_prune"]).To(Equal("tests,**/*.dist-info/RECORD,*.h,*.a"))
+			Expect(venvLayer.Metadata["pruned_paths"]).To(Equal([]string{
+				"include/site/python3.12/greenlet/greenlet.h",
+				"lib/python3.12/site-packages/flask-3.0.3.dist-info/RECORD",
+				"lib/python3.12/site-packages/flask/__pycache__/__init__.cpython-311.pyc",
+				"lib/python3.12/site-packages/flask/libspeedups.a",
+				"lib/python3.12/site-packages/flask/tests",
+			}))
+
+			Expect(buffer.String()).To(ContainLines(
+				"  Pruning the virtual env",
+				"    Removed 5 paths matching [tests, **/*.dist-info/RECORD, *.h, *.a] or compiled for another Python version",
+				"    Saved 83 B",
+			))
+		})
+
+		it("removes the paths matching BP_POETRY_VENV_PRUNE_PATTERNS", func() {
+			Expect(os.Setenv("BP_POETRY_VENV_PRUNE_PATTERNS", "lib/**/tests, *.a")).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info", "RECORD")).To(BeAnExistingFile())
+			Expect(filepath.Join(venvDir, "include", "site", "python3.12", "greenlet", "greenlet.h")).To(BeAnExistingFile())
+
+			Expect(result.Layers[0].Metadata["pruned_paths"]).To(Equal([]string{
+				"lib/python3.12/site-packages/flask/__pycache__/__init__.cpython-311.pyc",
+				"lib/python3.12/site-packages/flask/libspeedups.a",
+				"lib/python3.12/site-packages/flask/tests",
+			}))
+		})
+
+		it("does not prune the virtual env when BP_POETRY_VENV_PRUNE is false", func() {
+			Expect(os.Setenv("BP_POETRY_VENV_PRUNE", "false")).To(Succeed())
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(sitePackagesDir, "flask", "tests")).To(BeADirectory())
+			Expect(result.Layers[0].Metadata).NotTo(HaveKey("pruned_paths"))
+			Expect(buffer.String()).NotTo(ContainSubstring("Pruning the virtual env"))
+		})
+
+		context("when the pruned layer is reused", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("# poetry.lock"), 0600)).To(Succeed())
+
+				poetryLockSHA, err := fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "poetry.lock"))
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := toml.Marshal(map[string]interface{}{
+					"types": map[string]bool{"launch": true, "cache": true},
+					"metadata": map[string]interface{}{
+						"poetry_lock_sha":     poetryLockSHA,
+						"pyproject_toml_sha":  "",
+						"install_groups":      "main",
+						"install_extras":      "",
+						"install_root":        "",
+						"poetry_config":       "",
+						"compile_bytecode":    "",
+						"cpython_version":     "3.12.4",
+						"venv_prune":          "tests,**/*.dist-info/RECORD,*.h,*.a",
+						"strip_debug_symbols": "",
+						"lock_policy":         "strict",
+						"venv_dir":            venvDir,
+						"pruned_paths":        []string{"lib/python3.12/site-packages/flask/tests"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(layersDir, "poetry-venv.toml"), content, 0600)).To(Succeed())
+			})
+
+			it("keeps the list of pruned paths", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(0))
+				Expect(filepath.Join(sitePackagesDir, "flask", "tests")).To(BeADirectory())
+				Expect(result.Layers[0].Metadata["pruned_paths"]).To(Equal([]interface{}{"lib/python3.12/site-packages/flask/tests"}))
+				Expect(buffer.String()).NotTo(ContainSubstring("Pruning the virtual env"))
+			})
+
+			it("rebuilds the layer when the patterns have changed", func() {
+				Expect(os.Setenv("BP_POETRY_VENV_PRUNE_PATTERNS", "tests")).To(Succeed())
+
+				installProcess.ExecuteCall.Stub = func(_, _, _ string, _ []string, _ []servicebindings.Binding) (string, error) {
+					return venvDir, os.MkdirAll(sitePackagesDir, os.ModePerm)
+				}
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("venv pruning changed from 'tests,**/*.dist-info/RECORD,*.h,*.a' to 'tests'"))
+			})
+
+			it("removes the pruned virtual env before reinstalling when pruning is turned off", func() {
+				Expect(os.Setenv("BP_POETRY_VENV_PRUNE", "false")).To(Succeed())
+
+				var entries []os.DirEntry
+				installProcess.ExecuteCall.Stub = func(_, targetPath, _ string, _ []string, _ []servicebindings.Binding) (string, error) {
+					var err error
+					entries, err = os.ReadDir(targetPath)
+					return venvDir, err
+				}
+
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(entries).To(BeEmpty())
+				Expect(venvDir).NotTo(BeAnExistingFile())
+				Expect(result.Layers[0].Metadata["venv_prune"]).To(Equal(""))
+				Expect(result.Layers[0].Metadata).NotTo(HaveKey("pruned_paths"))
+				Expect(buffer.String()).To(ContainLines(
+					"    Rebuilding layer: venv pruning changed from 'tests,**/*.dist-info/RECORD,*.h,*.a' to ''",
+					"    Removing the cached virtual env, as it was pruned or stripped of debug symbols",
+				))
+			})
+		})
+
+		context("failure cases", func() {
+			it("returns an error when BP_POETRY_VENV_PRUNE is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_VENV_PRUNE", "yes please")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_VENV_PRUNE value 'yes please'")))
+			})
+
+			it("returns an error when a pattern of BP_POETRY_VENV_PRUNE_PATTERNS is malformed", func() {
+				Expect(os.Setenv("BP_POETRY_VENV_PRUNE_PATTERNS", "tests,[")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_VENV_PRUNE_PATTERNS value '['")))
+			})
+
+			it("returns an error when the virtual env cannot be pruned", func() {
+				Expect(os.Chmod(filepath.Join(sitePackagesDir, "flask"), 0500)).To(Succeed())
+				defer os.Chmod(filepath.Join(sitePackagesDir, "flask"), os.ModePerm)
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to prune")))
+				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			})
+		})
+	})
+
+	context("when the debug symbols of native extension modules are stripped", func() {
+		it.Before(func() {
+			Expect(os.Setenv("BP_POETRY_STRIP_DEBUG_SYMBOLS", "true")).To(Succeed())
+
+			debugSymbolStripper.StripCall.Returns.StripResult = poetryinstall.StripResult{
+				Stripped:   3,
+				SizeBefore: 48_200_000,
+				SizeAfter:  12_100_000,
+			}
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_STRIP_DEBUG_SYMBOLS")).To(Succeed())
+			Expect(os.Unsetenv("BP_POETRY_STRICT_VERIFICATION")).To(Succeed())
+		})
+
+		it("strips the modules in the site-packages directories and reports their size", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(1))
+			Expect(debugSymbolStripper.StripCall.Receives.PythonPath).To(Equal("some-python-path"))
+			Expect(debugSymbolStripper.StripCall.Receives.Strict).To(BeFalse())
+
+			Expect(result.Layers[0].Metadata["strip_debug_symbols"]).To(Equal("true"))
+
+			Expect(buffer.String()).To(ContainLines(
+				"  Stripping debug symbols from native extension modules",
+				"    Stripped 3 modules: 48.2 MB -> 12.1 MB",
+			))
+			Expect(buffer.String()).NotTo(ContainSubstring("Skipped"))
+		})
+
+		it("skips the modules recorded in RECORD when strict verification is on", func() {
+			Expect(os.Setenv("BP_POETRY_STRICT_VERIFICATION", "true")).To(Succeed())
+			debugSymbolStripper.StripCall.Returns.StripResult.Skipped = 2
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(debugSymbolStripper.StripCall.Receives.Strict).To(BeTrue())
+			Expect(result.Layers[0].Metadata["strip_debug_symbols"]).To(Equal("strict"))
+
+			Expect(buffer.String()).To(ContainLines(
+				"  Stripping debug symbols from native extension modules",
+				"    Stripped 3 modules: 48.2 MB -> 12.1 MB",
+				"    Skipped 2 modules whose hashes are recorded in RECORD (BP_POETRY_STRICT_VERIFICATION)",
+			))
+		})
+
+		it("does not strip the modules of the build-time groups", func() {
+			Expect(os.Setenv("BP_POETRY_INSTALL_BUILD_GROUPS", "dev")).To(Succeed())
+			defer os.Unsetenv("BP_POETRY_INSTALL_BUILD_GROUPS")
+
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(1))
+			Expect(result.Layers[1].Name).To(Equal("poetry-venv-build"))
+			Expect(result.Layers[1].Metadata["strip_debug_symbols"]).To(Equal(""))
+		})
+
+		it("does not strip the modules when BP_POETRY_STRIP_DEBUG_SYMBOLS is false", func() {
+			Expect(os.Setenv("BP_POETRY_STRIP_DEBUG_SYMBOLS", "false")).To(Succeed())
+
+			_, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(0))
+			Expect(buffer.String()).NotTo(ContainSubstring("Stripping debug symbols"))
+		})
+
+		context("when the layer is reused", func() {
+			it.Before(func() {
+				venvDir := filepath.Join(layersDir, "poetry-venv", "some-venv-dir")
+				Expect(os.MkdirAll(venvDir, os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("# poetry.lock"), 0600)).To(Succeed())
+
+				poetryLockSHA, err := fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "poetry.lock"))
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := toml.Marshal(map[string]interface{}{
+					"types": map[string]bool{"launch": true, "cache": true},
+					"metadata": map[string]interface{}{
+						"poetry_lock_sha":     poetryLockSHA,
+						"pyproject_toml_sha":  "",
+						"install_groups":      "main",
+						"install_extras":      "",
+						"install_root":        "",
+						"poetry_config":       "",
+						"compile_bytecode":    "",
+						"cpython_version":     "3.12.4",
+						"venv_prune":          "",
+						"strip_debug_symbols": "true",
+						"lock_policy":         "strict",
+						"venv_dir":            venvDir,
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(layersDir, "poetry-venv.toml"), content, 0600)).To(Succeed())
+			})
+
+			it("does not strip the modules again", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(0))
+				Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(0))
+			})
+
+			it("rebuilds the layer when strict verification has been turned on", func() {
+				Expect(os.Setenv("BP_POETRY_STRICT_VERIFICATION", "true")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding layer: debug symbol stripping changed from 'true' to 'strict'"))
+			})
+
+			it("removes the stripped virtual env before reinstalling when stripping is turned off", func() {
+				Expect(os.Setenv("BP_POETRY_STRIP_DEBUG_SYMBOLS", "false")).To(Succeed())
+
+				var entries []os.DirEntry
+				installProcess.ExecuteCall.Stub = func(_, targetPath, _ string, _ []string, _ []servicebindings.Binding) (string, error) {
+					var err error
+					entries, err = os.ReadDir(targetPath)
+					return filepath.Join(targetPath, "some-venv-dir"), err
+				}
+
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installProcess.ExecuteCall.CallCount).To(Equal(1))
+				Expect(entries).To(BeEmpty())
+				Expect(debugSymbolStripper.StripCall.CallCount).To(Equal(0))
+				Expect(result.Layers[0].Metadata["strip_debug_symbols"]).To(Equal(""))
+				Expect(buffer.String()).To(ContainLines(
+					"    Rebuilding layer: debug symbol stripping changed from 'true' to ''",
+					"    Removing the cached virtual env, as it was pruned or stripped of debug symbols",
+				))
+			})
+		})
+
+		context("failure cases", func() {
+			it("returns an error when BP_POETRY_STRIP_DEBUG_SYMBOLS is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_STRIP_DEBUG_SYMBOLS", "yes please")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_STRIP_DEBUG_SYMBOLS value 'yes please'")))
+			})
+
+			it("returns an error when BP_POETRY_STRICT_VERIFICATION is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_STRICT_VERIFICATION", "yes please")).To(Succeed())
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_STRICT_VERIFICATION value 'yes please'")))
+			})
+
+			it("returns an error when stripping the modules fails", func() {
+				debugSymbolStripper.StripCall.Returns.Error = errors.New("failed to strip")
+
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to strip"))
+			})
+		})
+	})
+
+	context("when build-time groups are configured", func() {
+		var installations []string
+
+		it.Before(func() {
+			Expect(os.Setenv("BP_POETRY_INSTALL_BUILD_GROUPS", "dev,test")).To(Succeed())
+
+			installations = nil
+			installProcess.ExecuteCall.Stub = func(_, targetDir, _ string, extraGroups []string, _ []servicebindings.Binding) (string, error) {
+				installations = append(installations, fmt.Sprintf("%s %v", filepath.Base(targetDir), extraGroups))
+				return filepath.Join(targetDir, "some-venv-dir"), nil
+			}
+			pythonPathProcess.ExecuteCall.Stub = func(venvDir string) (string, error) {
+				return filepath.Join(venvDir, "site-packages"), nil
+			}
+
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+		})
+
+		it.After(func() {
+			Expect(os.Unsetenv("BP_POETRY_INSTALL_BUILD_GROUPS")).To(Succeed())
+		})
+
+		it("installs the build-time groups into a build-only layer", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(installations).To(Equal([]string{
+				"poetry-venv []",
+				"poetry-venv-build [dev test]",
+			}))
+
+			layers := result.Layers
+			Expect(layers).To(HaveLen(2))
+
+			venvLayer := layers[0]
+			Expect(venvLayer.Name).To(Equal("poetry-venv"))
+			Expect(venvLayer.Launch).To(BeTrue())
+			Expect(venvLayer.Metadata["install_groups"]).To(Equal("main"))
+
+			buildVenvLayer := layers[1]
+			Expect(buildVenvLayer.Name).To(Equal("poetry-venv-build"))
+			Expect(buildVenvLayer.Path).To(Equal(filepath.Join(layersDir, "poetry-venv-build")))
+			Expect(buildVenvLayer.Build).To(BeTrue())
+			Expect(buildVenvLayer.Launch).To(BeFalse())
+			Expect(buildVenvLayer.Cache).To(BeTrue())
+			Expect(buildVenvLayer.Metadata["install_groups"]).To(Equal("main,dev,test"))
+			Expect(buildVenvLayer.Metadata["venv_dir"]).To(Equal(filepath.Join(layersDir, "poetry-venv-build", "some-venv-dir")))
+
+			Expect(buildVenvLayer.SharedEnv).To(BeEmpty())
+			Expect(buildVenvLayer.LaunchEnv).To(BeEmpty())
+			Expect(buildVenvLayer.BuildEnv).To(Equal(packit.Environment{
+				"POETRY_VIRTUALENVS_PATH.override": filepath.Join(layersDir, "poetry-venv-build"),
+				"VIRTUAL_ENV.override":             filepath.Join(layersDir, "poetry-venv-build", "some-venv-dir"),
+				"PYTHONPATH.prepend":               filepath.Join(layersDir, "poetry-venv-build", "some-venv-dir", "site-packages"),
+				"PYTHONPATH.delim":                 ":",
+				"PATH.prepend":                     filepath.Join(layersDir, "poetry-venv-build", "some-venv-dir", "bin"),
+				"PATH.delim":                       ":",
+			}))
+
+			Expect(buffer.String()).To(ContainSubstring("Executing build process for build-time groups [dev, test]"))
+		})
+
+		context("when the build-only layer is reused", func() {
+			it.Before(func() {
+				buildVenvDir := filepath.Join(layersDir, "poetry-venv-build", "some-venv-dir")
+				Expect(os.MkdirAll(buildVenvDir, os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("# poetry.lock"), 0600)).To(Succeed())
+
+				poetryLockSHA, err := fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "poetry.lock"))
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := toml.Marshal(map[string]interface{}{
+					"types": map[string]bool{"build": true, "cache": true},
+					"metadata": map[string]interface{}{
+						"poetry_lock_sha":     poetryLockSHA,
+						"pyproject_toml_sha":  "",
+						"install_groups":      "main,dev,test",
+						"install_extras":      "",
+						"install_root":        "",
+						"poetry_config":       "",
+						"compile_bytecode":    "",
+						"cpython_version":     "3.12.4",
+						"venv_prune":          "",
+						"strip_debug_symbols": "",
+						"lock_policy":         "strict",
+						"venv_dir":            buildVenvDir,
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(layersDir, "poetry-venv-build.toml"), content, 0600)).To(Succeed())
+			})
+
+			it("only installs into the launch layer", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(installations).To(Equal([]string{"poetry-venv []"}))
+				Expect(result.Layers[1].Name).To(Equal("poetry-venv-build"))
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Reusing cached layer %s", filepath.Join(layersDir, "poetry-venv-build"))))
+			})
+		})
+
+		context("when the build-time install fails", func() {
+			it.Before(func() {
+				installProcess.ExecuteCall.Stub = func(_, targetDir, _ string, extraGroups []string, _ []servicebindings.Binding) (string, error) {
+					if len(extraGroups) > 0 {
+						return "", errors.New("could not install build-time groups")
+					}
+					return filepath.Join(targetDir, "some-venv-dir"), nil
+				}
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("could not install build-time groups"))
+			})
+		})
+	})
+
+	context("install process utilizes cache", func() {
+		it.Before(func() {
+			installProcess.ExecuteCall.Stub = func(_, _, cachePath string, _ []string, _ []servicebindings.Binding) (string, error) {
+				err := os.MkdirAll(filepath.Join(cachePath, "something"), os.ModePerm)
+				if err != nil {
+					return "", fmt.Errorf("issue with stub call: %+v", err)
+				}
+
+				return "some-cached-venv-dir", nil
+			}
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+			entryResolver.MergeLayerTypesCall.Returns.Build = true
+		})
+
+		it("result should include a cache layer", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			layers := result.Layers
+			Expect(layers).To(HaveLen(2))
+
+			venvLayer := layers[0]
+			Expect(venvLayer.Name).To(Equal("poetry-venv"))
+
+			Expect(venvLayer.Build).To(BeTrue())
+			Expect(venvLayer.Launch).To(BeTrue())
+			Expect(venvLayer.Cache).To(BeTrue())
+
+			Expect(venvLayer.BuildEnv).To(BeEmpty())
+			Expect(venvLayer.LaunchEnv).To(BeEmpty())
+			Expect(venvLayer.ProcessLaunchEnv).To(BeEmpty())
+
+			Expect(venvLayer.SharedEnv).To(HaveLen(6))
+			Expect(venvLayer.SharedEnv["PATH.prepend"]).To(Equal("some-cached-venv-dir/bin"))
+			Expect(venvLayer.SharedEnv["PATH.delim"]).To(Equal(":"))
+			Expect(venvLayer.SharedEnv["PYTHONPATH.prepend"]).To(Equal("some-python-path"))
+			Expect(venvLayer.SharedEnv["PYTHONPATH.delim"]).To(Equal(":"))
+			Expect(venvLayer.SharedEnv["POETRY_VIRTUALENVS_PATH.default"]).To(Equal(filepath.Join(layersDir, "poetry-venv")))
+			Expect(venvLayer.SharedEnv["VIRTUAL_ENV.default"]).To(Equal("some-cached-venv-dir"))
+
+			cacheLayer := layers[1]
+			Expect(cacheLayer.Name).To(Equal("cache"))
+			Expect(cacheLayer.Path).To(Equal(filepath.Join(layersDir, "cache")))
+			Expect(cacheLayer.Build).To(BeFalse())
+			Expect(cacheLayer.Launch).To(BeFalse())
+			Expect(cacheLayer.Cache).To(BeTrue())
+			Expect(cacheLayer.BuildEnv).To(BeEmpty())
+			Expect(cacheLayer.LaunchEnv).To(BeEmpty())
+			Expect(cacheLayer.ProcessLaunchEnv).To(BeEmpty())
+			Expect(cacheLayer.SharedEnv).To(BeEmpty())
+			Expect(cacheLayer.Metadata).To(BeEmpty())
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the layers directory cannot be written to", func() {
+			it.Before(func() {
+				Expect(os.Chmod(layersDir, 0000)).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Chmod(layersDir, os.ModePerm)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			})
+		})
+
+		context("when the Python version lookup process returns an error", func() {
+			it.Before(func() {
+				pythonVersionProcess.ExecuteCall.Returns.Error = errors.New("could not run Python version process")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("could not run Python version process"))
+			})
+		})
+
+		context("when the install extras are misconfigured", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_INSTALL_ALL_EXTRAS", "some-value")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_ALL_EXTRAS")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_INSTALL_ALL_EXTRAS value 'some-value'")))
+			})
+		})
+
+		context("when the root project installation is misconfigured", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_INSTALL_ROOT", "some-value")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_INSTALL_ROOT")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_INSTALL_ROOT value 'some-value'")))
+			})
+		})
+
+		context("when BP_POETRY_LOCK_POLICY is not supported", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_LOCK_POLICY", "some-value")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_LOCK_POLICY")).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError(ContainSubstring("BP_POETRY_LOCK_POLICY value 'some-value' is not supported")))
+			})
+		})
+
+		context("when the service bindings cannot be resolved", func() {
+			it.Before(func() {
+				bindingResolver.ResolveCall.Returns.Error = errors.New("failed to resolve bindings")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to resolve bindings"))
+			})
+		})
+
+		context("when install process returns an error", func() {
+			it.Before(func() {
+				installProcess.ExecuteCall.Returns.Error = errors.New("could not run install process")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("could not run install process"))
+			})
+		})
+
+		context("when normalizing the virtual env returns an error", func() {
+			it.Before(func() {
+				venvNormalizer.NormalizeCall.Returns.Error = errors.New("could not normalize the virtual env")
+			})
+
+			it("returns an error", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("could not normalize the virtual env"))
 			})
 		})
 
@@ -16,5 +16,20 @@ const Poetry = "poetry"
 // installed to.
 const VenvLayerName = "poetry-venv"
 
+// BuildVenvLayerName is the name of the layer where the venv dependencies,
+// including the build-time groups, are installed to when build-time groups
+// are configured. The layer is only available during the build phase.
+const BuildVenvLayerName = "poetry-venv-build"
+
 // CacheLayerName holds the poetry cache.
 const CacheLayerName = "cache"
+
+// The values of BP_POETRY_LOCK_POLICY, which control how a poetry.lock that is
+// not consistent with pyproject.toml is handled: LockPolicyStrict fails the
+// build, LockPolicyWarn logs a warning and LockPolicyRelock updates
+// poetry.lock during the build.
+const (
+	LockPolicyStrict = "strict"
+	LockPolicyWarn   = "warn"
+	LockPolicyRelock = "relock"
+)
@@ -0,0 +1,210 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+)
+
+// StripResult describes the native extension modules of a virtual env that
+// were stripped of their debug symbols.
+type StripResult struct {
+	// Stripped is the number of modules that were stripped.
+	Stripped int
+
+	// Skipped is the number of modules with debug symbols that were left
+	// unchanged, as their hashes are recorded in a RECORD file.
+	Skipped int
+
+	// SizeBefore and SizeAfter are the sizes of the stripped modules before
+	// and after they were stripped.
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// PoetryDebugSymbolStripper implements the DebugSymbolStripper interface.
+type PoetryDebugSymbolStripper struct {
+	executable Executable
+}
+
+// NewPoetryDebugSymbolStripper creates an instance of the
+// PoetryDebugSymbolStripper given an Executable that invokes strip.
+func NewPoetryDebugSymbolStripper(executable Executable) PoetryDebugSymbolStripper {
+	return PoetryDebugSymbolStripper{
+		executable: executable,
+	}
+}
+
+// Strip removes the debug sections from the ELF shared objects, such as the
+// native extension modules of the installed packages, in the site-packages
+// directories of pythonPath, separated by os.PathListSeparator. Shared
+// objects without debug sections are left unchanged. When strict is set, the
+// shared objects whose hashes are recorded in the RECORD file of their package
+// are skipped, so that the installed packages still match their RECORD. The
+// modification times of the stripped files are kept.
+func (s PoetryDebugSymbolStripper) Strip(pythonPath string, strict bool) (StripResult, error) {
+	var result StripResult
+	for _, sitePackagesDir := range filepath.SplitList(pythonPath) {
+		var recorded map[string]bool
+		if strict {
+			var err error
+			recorded, err = recordedFiles(sitePackagesDir)
+			if err != nil {
+				return StripResult{}, err
+			}
+		}
+
+		err := filepath.WalkDir(sitePackagesDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !entry.Type().IsRegular() || !isSharedObjectName(entry.Name()) {
+				return nil
+			}
+
+			debug, err := hasDebugSections(path)
+			if err != nil || !debug {
+				return err
+			}
+
+			if recorded[path] {
+				result.Skipped++
+				return nil
+			}
+
+			before, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			buffer := bytes.NewBuffer(nil)
+			err = s.executable.Execute(pexec.Execution{
+				Args:   []string{"--strip-debug", "--preserve-dates", path},
+				Stdout: buffer,
+				Stderr: buffer,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to strip the debug symbols of '%s':\n%s\nerror: %w", path, buffer, err)
+			}
+
+			after, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			result.Stripped++
+			result.SizeBefore += before.Size()
+			result.SizeAfter += after.Size()
+
+			return nil
+		})
+		if err != nil {
+			return StripResult{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// stripDebugSymbols returns whether the native extension modules of the
+// virtual env are stripped of their debug symbols, as configured by
+// BP_POETRY_STRIP_DEBUG_SYMBOLS.
+func stripDebugSymbols() (bool, error) {
+	value, exists := os.LookupEnv("BP_POETRY_STRIP_DEBUG_SYMBOLS")
+	if !exists {
+		return false, nil
+	}
+
+	strip, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse BP_POETRY_STRIP_DEBUG_SYMBOLS value '%s': %w", value, err)
+	}
+
+	return strip, nil
+}
+
+// strictVerification returns whether the files whose hashes are recorded in
+// the RECORD files of the installed packages must be left unchanged, as
+// configured by BP_POETRY_STRICT_VERIFICATION.
+func strictVerification() (bool, error) {
+	value, exists := os.LookupEnv("BP_POETRY_STRICT_VERIFICATION")
+	if !exists {
+		return false, nil
+	}
+
+	strict, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse BP_POETRY_STRICT_VERIFICATION value '%s': %w", value, err)
+	}
+
+	return strict, nil
+}
+
+// recordedFiles returns the paths of the files whose hashes are recorded in
+// the RECORD files of the packages installed into sitePackagesDir.
+func recordedFiles(sitePackagesDir string) (map[string]bool, error) {
+	records, err := filepath.Glob(filepath.Join(sitePackagesDir, "*.dist-info", "RECORD"))
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := map[string]bool{}
+	for _, record := range records {
+		files, err := readRecord(record)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.Digest != nil && file.Digest.Value != "" {
+				recorded[filepath.Join(sitePackagesDir, filepath.FromSlash(file.Path))] = true
+			}
+		}
+	}
+
+	return recorded, nil
+}
+
+// isSharedObjectName reports whether the name of a file is that of a shared
+// object, such as _multiarray_umath.cpython-312-x86_64-linux-gnu.so or
+// libgfortran.so.5.
+func isSharedObjectName(name string) bool {
+	return strings.HasSuffix(name, ".so") || strings.Contains(name, ".so.")
+}
+
+// hasDebugSections reports whether the file at path is an ELF shared object
+// with debug sections. Files that are not ELF files are reported as having
+// none.
+func hasDebugSections(path string) (bool, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	if file.Type != elf.ET_DYN {
+		return false, nil
+	}
+
+	for _, section := range file.Sections {
+		if strings.HasPrefix(section.Name, ".debug_") || strings.HasPrefix(section.Name, ".zdebug_") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,214 @@
+package poetryinstall_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+	"github.com/paketo-buildpacks/poetry-install/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testDebugSymbolStripper(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		sitePackagesDir string
+		stripped        []string
+
+		executable *fakes.Executable
+		stripper   poetryinstall.PoetryDebugSymbolStripper
+	)
+
+	// sharedObject returns the content of an ELF file of the given type with
+	// a section of the given size for each of the section names.
+	sharedObject := func(fileType elf.Type, sections map[string]int) []byte {
+		names := []string{".shstrtab"}
+		for name := range sections {
+			names = append(names, name)
+		}
+
+		shstrtab := []byte{0}
+		nameOffsets := map[string]uint32{}
+		for _, name := range names {
+			nameOffsets[name] = uint32(len(shstrtab))
+			shstrtab = append(append(shstrtab, name...), 0)
+		}
+
+		data := bytes.NewBuffer(nil)
+		headers := []elf.Section64{{}}
+		offset := uint64(binary.Size(elf.Header64{}))
+		for _, name := range names {
+			content := shstrtab
+			sectionType := elf.SHT_STRTAB
+			if name != ".shstrtab" {
+				content = bytes.Repeat([]byte{0x42}, sections[name])
+				sectionType = elf.SHT_PROGBITS
+			}
+
+			headers = append(headers, elf.Section64{
+				Name:      nameOffsets[name],
+				Type:      uint32(sectionType),
+				Off:       offset + uint64(data.Len()),
+				Size:      uint64(len(content)),
+				Addralign: 1,
+			})
+			data.Write(content)
+		}
+
+		header := elf.Header64{
+			Type:      uint16(fileType),
+			Machine:   uint16(elf.EM_X86_64),
+			Version:   uint32(elf.EV_CURRENT),
+			Shoff:     offset + uint64(data.Len()),
+			Ehsize:    uint16(binary.Size(elf.Header64{})),
+			Shentsize: uint16(binary.Size(elf.Section64{})),
+			Shnum:     uint16(len(headers)),
+			Shstrndx:  1,
+		}
+		copy(header.Ident[:], elf.ELFMAG)
+		header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+		header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+		header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+		buffer := bytes.NewBuffer(nil)
+		Expect(binary.Write(buffer, binary.LittleEndian, header)).To(Succeed())
+		buffer.Write(data.Bytes())
+		Expect(binary.Write(buffer, binary.LittleEndian, headers)).To(Succeed())
+
+		return buffer.Bytes()
+	}
+
+	it.Before(func() {
+		var err error
+		sitePackagesDir, err = os.MkdirTemp("", "site-packages")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "numpy", "core"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "numpy.libs"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "numpy-2.0.0.dist-info"), os.ModePerm)).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy", "core", "_multiarray_umath.cpython-312-x86_64-linux-gnu.so"), sharedObject(elf.ET_DYN, map[string]int{".text": 100, ".debug_info": 900}), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy.libs", "libgfortran.so.5"), sharedObject(elf.ET_DYN, map[string]int{".text": 100, ".debug_line": 400}), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy", "core", "_simd.cpython-312-x86_64-linux-gnu.so"), sharedObject(elf.ET_DYN, map[string]int{".text": 100}), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy", "core", "libnpymath.so"), sharedObject(elf.ET_REL, map[string]int{".text": 100, ".debug_info": 900}), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy", "core", "linker.so"), []byte("INPUT(-lnpymath)\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy-2.0.0.dist-info", "RECORD"), []byte(
+			"numpy/core/_multiarray_umath.cpython-312-x86_64-linux-gnu.so,sha256=abc,1000\n"+
+				"numpy-2.0.0.dist-info/RECORD,,\n",
+		), 0644)).To(Succeed())
+
+		stripped = nil
+		executable = &fakes.Executable{}
+		executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			path := execution.Args[len(execution.Args)-1]
+			stripped = append(stripped, path)
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			if err := os.WriteFile(path, sharedObject(elf.ET_DYN, map[string]int{".text": 100}), info.Mode()); err != nil {
+				return err
+			}
+
+			return os.Chtimes(path, info.ModTime(), info.ModTime())
+		}
+
+		stripper = poetryinstall.NewPoetryDebugSymbolStripper(executable)
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(sitePackagesDir)).To(Succeed())
+	})
+
+	it("strips the shared objects with debug sections", func() {
+		timestamp := time.Unix(315532801, 0)
+		path := filepath.Join(sitePackagesDir, "numpy", "core", "_multiarray_umath.cpython-312-x86_64-linux-gnu.so")
+		Expect(os.Chtimes(path, timestamp, timestamp)).To(Succeed())
+
+		result, err := stripper.Strip(sitePackagesDir, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(executable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--strip-debug", "--preserve-dates", filepath.Join(sitePackagesDir, "numpy.libs", "libgfortran.so.5")}))
+		Expect(stripped).To(ConsistOf(
+			path,
+			filepath.Join(sitePackagesDir, "numpy.libs", "libgfortran.so.5"),
+		))
+
+		unstripped := len(sharedObject(elf.ET_DYN, map[string]int{".text": 100}))
+		Expect(result).To(Equal(poetryinstall.StripResult{
+			Stripped:   2,
+			SizeBefore: int64(len(sharedObject(elf.ET_DYN, map[string]int{".text": 100, ".debug_info": 900})) + len(sharedObject(elf.ET_DYN, map[string]int{".text": 100, ".debug_line": 400}))),
+			SizeAfter:  int64(2 * unstripped),
+		}))
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ModTime()).To(Equal(timestamp))
+	})
+
+	it("strips the shared objects in each of the site-packages directories", func() {
+		platlibDir, err := os.MkdirTemp("", "platlib")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(platlibDir)
+
+		Expect(os.WriteFile(filepath.Join(platlibDir, "_cffi_backend.cpython-312-x86_64-linux-gnu.so"), sharedObject(elf.ET_DYN, map[string]int{".debug_info": 10}), 0755)).To(Succeed())
+
+		result, err := stripper.Strip(sitePackagesDir+string(os.PathListSeparator)+platlibDir, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result.Stripped).To(Equal(3))
+		Expect(stripped).To(ContainElement(filepath.Join(platlibDir, "_cffi_backend.cpython-312-x86_64-linux-gnu.so")))
+	})
+
+	context("when strict verification is on", func() {
+		it("skips the shared objects whose hashes are recorded in RECORD", func() {
+			result, err := stripper.Strip(sitePackagesDir, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stripped).To(Equal([]string{filepath.Join(sitePackagesDir, "numpy.libs", "libgfortran.so.5")}))
+			Expect(result.Stripped).To(Equal(1))
+			Expect(result.Skipped).To(Equal(1))
+		})
+	})
+
+	context("failure cases", func() {
+		it("returns an error when strip fails", func() {
+			executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+				fmt.Fprintln(execution.Stderr, "strip: some-error")
+				return errors.New("exit status 1")
+			}
+
+			_, err := stripper.Strip(sitePackagesDir, false)
+			Expect(err).To(MatchError(ContainSubstring("failed to strip the debug symbols of")))
+			Expect(err).To(MatchError(ContainSubstring("strip: some-error")))
+			Expect(err).To(MatchError(ContainSubstring("exit status 1")))
+		})
+
+		it("returns an error when a RECORD file is malformed", func() {
+			Expect(os.WriteFile(filepath.Join(sitePackagesDir, "numpy-2.0.0.dist-info", "RECORD"), []byte("\"unterminated\n"), 0644)).To(Succeed())
+
+			_, err := stripper.Strip(sitePackagesDir, true)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to parse '%s'", filepath.Join(sitePackagesDir, "numpy-2.0.0.dist-info", "RECORD")))))
+		})
+
+		it("returns an error when a shared object cannot be read", func() {
+			Expect(os.Chmod(filepath.Join(sitePackagesDir, "numpy.libs", "libgfortran.so.5"), 0000)).To(Succeed())
+
+			_, err := stripper.Strip(sitePackagesDir, false)
+			Expect(err).To(MatchError(ContainSubstring("permission denied")))
+		})
+	})
+}
@@ -1,7 +1,10 @@
 package poetryinstall
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/fs"
@@ -12,16 +15,30 @@ import (
 type BuildPlanMetadata struct {
 	// Build denotes the dependency is needed at build-time.
 	Build bool `toml:"build"`
+
+	// Version denotes the version constraint of the dependency.
+	Version string `toml:"version,omitempty"`
+
+	// VersionSource denotes where the version constraint was declared.
+	VersionSource string `toml:"version-source,omitempty"`
 }
 
 // Detect will return a packit.DetectFunc that will be invoked during the
 // detect phase of the buildpack lifecycle.
 //
-// Detection will contribute a Build Plan that provides poetry-venv,
-// and requires cpython, pip, and poetry at build.
+// Detection passes when the app has a pyproject.toml that uses Poetry: it
+// declares a [tool.poetry] table, is built with the poetry-core build backend,
+// or has a poetry.lock next to it. Detection will contribute a Build Plan that
+// provides poetry-venv, and requires cpython, pip, and poetry at build. The
+// cpython requirement carries the python version constraint declared in
+// pyproject.toml, if any. The poetry requirement carries the requires-poetry
+// constraint from pyproject.toml or, failing that, the major version of the
+// Poetry that generated poetry.lock. When BP_POETRY_REQUIRE_LOCK is set,
+// detection returns an error for a project without a poetry.lock.
 func Detect() packit.DetectFunc {
 	return func(context packit.DetectContext) (packit.DetectResult, error) {
-		exists, err := fs.Exists(filepath.Join(context.WorkingDir, "pyproject.toml"))
+		pyProjectPath := filepath.Join(context.WorkingDir, "pyproject.toml")
+		exists, err := fs.Exists(pyProjectPath)
 		if err != nil {
 			return packit.DetectResult{}, err
 		}
@@ -30,6 +47,59 @@ func Detect() packit.DetectFunc {
 			return packit.DetectResult{}, packit.Fail.WithMessage("no 'pyproject.toml' found")
 		}
 
+		pyProject, err := ParsePyProject(pyProjectPath)
+		if err != nil {
+			return packit.DetectResult{}, err
+		}
+
+		hasLock, err := fs.Exists(filepath.Join(context.WorkingDir, "poetry.lock"))
+		if err != nil {
+			return packit.DetectResult{}, err
+		}
+
+		if !pyProject.HasPoetryTable() && !pyProject.UsesPoetryCore() && !hasLock {
+			return packit.DetectResult{}, packit.Fail.WithMessage("'pyproject.toml' does not use Poetry: no [tool.poetry] table, no poetry-core build backend and no 'poetry.lock' found")
+		}
+
+		if !hasLock {
+			required, err := requireLock()
+			if err != nil {
+				return packit.DetectResult{}, err
+			}
+
+			if required {
+				return packit.DetectResult{}, errors.New("no 'poetry.lock' found, but BP_POETRY_REQUIRE_LOCK is set: run 'poetry lock' and commit poetry.lock")
+			}
+		}
+
+		poetryMetadata := BuildPlanMetadata{
+			Build: true,
+		}
+
+		if version := semverConstraint(pyProject.Tool.Poetry.RequiresPoetry); version != "" {
+			poetryMetadata.Version = version
+			poetryMetadata.VersionSource = "pyproject.toml"
+		} else if hasLock {
+			lock, err := ParsePoetryLock(filepath.Join(context.WorkingDir, "poetry.lock"))
+			if err != nil {
+				return packit.DetectResult{}, err
+			}
+
+			if lock.PoetryVersion != "" {
+				poetryMetadata.Version = fmt.Sprintf("%s.*", strings.Split(lock.PoetryVersion, ".")[0])
+				poetryMetadata.VersionSource = "poetry.lock"
+			}
+		}
+
+		cpythonMetadata := BuildPlanMetadata{
+			Build: true,
+		}
+
+		if version := semverConstraint(pyProject.PythonConstraint()); version != "" {
+			cpythonMetadata.Version = version
+			cpythonMetadata.VersionSource = "pyproject.toml"
+		}
+
 		return packit.DetectResult{
 			Plan: packit.BuildPlan{
 				Provides: []packit.BuildPlanProvision{
@@ -37,16 +107,12 @@ func Detect() packit.DetectFunc {
 				},
 				Requires: []packit.BuildPlanRequirement{
 					{
-						Name: CPython,
-						Metadata: BuildPlanMetadata{
-							Build: true,
-						},
+						Name:     CPython,
+						Metadata: cpythonMetadata,
 					},
 					{
-						Name: Poetry,
-						Metadata: BuildPlanMetadata{
-							Build: true,
-						},
+						Name:     Poetry,
+						Metadata: poetryMetadata,
 					},
 				},
 			},
@@ -24,7 +24,7 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 		workingDir, err = os.MkdirTemp("", "working-dir")
 		Expect(err).NotTo(HaveOccurred())
 
-		err = os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte{}, 0644)
+		err = os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte("[tool.poetry]\nname = \"some-app\"\n"), 0644)
 		Expect(err).NotTo(HaveOccurred())
 
 		detect = poetryinstall.Detect()
@@ -58,6 +58,159 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			}))
 		})
 
+		context("when pyproject.toml declares a python version constraint", func() {
+			var cpythonMetadata = func(pyProject string) poetryinstall.BuildPlanMetadata {
+				Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(pyProject), 0644)).To(Succeed())
+
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan.Requires[0].Name).To(Equal(poetryinstall.CPython))
+
+				return result.Plan.Requires[0].Metadata.(poetryinstall.BuildPlanMetadata)
+			}
+
+			it("requires the version from [tool.poetry.dependencies]", func() {
+				Expect(cpythonMetadata(`
+[tool.poetry.dependencies]
+python = "^3.10"
+`)).To(Equal(poetryinstall.BuildPlanMetadata{
+					Build:         true,
+					Version:       "^3.10",
+					VersionSource: "pyproject.toml",
+				}))
+			})
+
+			it("requires the version from [project] requires-python", func() {
+				Expect(cpythonMetadata(`
+[project]
+requires-python = ">=3.11,<3.12"
+
+[tool.poetry]
+`)).To(Equal(poetryinstall.BuildPlanMetadata{
+					Build:         true,
+					Version:       ">=3.11, <3.12",
+					VersionSource: "pyproject.toml",
+				}))
+			})
+
+			it("prefers [tool.poetry.dependencies] over [project] requires-python", func() {
+				Expect(cpythonMetadata(`
+[project]
+requires-python = ">=3.9"
+
+[tool.poetry.dependencies]
+python = ">=3.10,<3.13"
+`).Version).To(Equal(">=3.10, <3.13"))
+			})
+
+			it("translates PEP 440 operators", func() {
+				Expect(cpythonMetadata(`
+[project]
+requires-python = "~=3.10"
+
+[tool.poetry]
+`).Version).To(Equal(">=3.10, <4"))
+
+				Expect(cpythonMetadata(`
+[project]
+requires-python = "~=3.11.2"
+
+[tool.poetry]
+`).Version).To(Equal(">=3.11.2, <3.12"))
+
+				Expect(cpythonMetadata(`
+[project]
+requires-python = "==3.12.*"
+
+[tool.poetry]
+`).Version).To(Equal("3.12.*"))
+
+				Expect(cpythonMetadata(`
+[tool.poetry.dependencies]
+python = ">=3.8,<3.9 || >=3.11"
+`).Version).To(Equal(">=3.8, <3.9 || >=3.11"))
+			})
+
+			it("does not require a version when any version is allowed", func() {
+				Expect(cpythonMetadata(`
+[tool.poetry.dependencies]
+python = "*"
+`)).To(Equal(poetryinstall.BuildPlanMetadata{
+					Build: true,
+				}))
+			})
+
+			it("does not require a version when the constraint cannot be translated", func() {
+				Expect(cpythonMetadata(`
+[project]
+requires-python = "~=3"
+
+[tool.poetry]
+`)).To(Equal(poetryinstall.BuildPlanMetadata{
+					Build: true,
+				}))
+			})
+		})
+
+		context("when the project declares a poetry version", func() {
+			var poetryMetadata = func() poetryinstall.BuildPlanMetadata {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan.Requires[1].Name).To(Equal(poetryinstall.Poetry))
+
+				return result.Plan.Requires[1].Metadata.(poetryinstall.BuildPlanMetadata)
+			}
+
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte(`# This file is automatically @generated by Poetry 1.8.5 and should not be changed by hand.
+
+[[package]]
+name = "flask"
+`), 0644)).To(Succeed())
+			})
+
+			it("requires the major version of the poetry that generated poetry.lock", func() {
+				Expect(poetryMetadata()).To(Equal(poetryinstall.BuildPlanMetadata{
+					Build:         true,
+					Version:       "1.*",
+					VersionSource: "poetry.lock",
+				}))
+			})
+
+			context("when pyproject.toml sets requires-poetry", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(`
+[tool.poetry]
+requires-poetry = ">=2.0,<3.0"
+`), 0644)).To(Succeed())
+				})
+
+				it("requires that version", func() {
+					Expect(poetryMetadata()).To(Equal(poetryinstall.BuildPlanMetadata{
+						Build:         true,
+						Version:       ">=2.0, <3.0",
+						VersionSource: "pyproject.toml",
+					}))
+				})
+			})
+
+			context("when the poetry.lock header is missing", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("[[package]]\n"), 0644)).To(Succeed())
+				})
+
+				it("does not require a version", func() {
+					Expect(poetryMetadata()).To(Equal(poetryinstall.BuildPlanMetadata{
+						Build: true,
+					}))
+				})
+			})
+		})
+
 		context("when there is no pyproject.toml file", func() {
 			it.Before(func() {
 				Expect(os.Remove(filepath.Join(workingDir, "pyproject.toml"))).To(Succeed())
@@ -71,7 +224,114 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when the pyproject.toml file does not use Poetry", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(`
+[project]
+name = "some-app"
+
+[build-system]
+requires = ["hatchling"]
+build-backend = "hatchling.build"
+`), 0644)).To(Succeed())
+			})
+
+			it("fails detection", func() {
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).To(MatchError(packit.Fail.WithMessage("'pyproject.toml' does not use Poetry: no [tool.poetry] table, no poetry-core build backend and no 'poetry.lock' found")))
+			})
+
+			context("when there is a poetry.lock file", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte{}, 0644)).To(Succeed())
+				})
+
+				it("passes detection", func() {
+					result, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.Plan.Provides).To(Equal([]packit.BuildPlanProvision{
+						{Name: poetryinstall.PoetryVenv},
+					}))
+				})
+			})
+		})
+
+		context("when the pyproject.toml file uses the poetry-core build backend", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte(`
+[project]
+name = "some-app"
+
+[build-system]
+requires = ["poetry-core>=2.0.0,<3.0.0"]
+build-backend = "poetry.core.masonry.api"
+`), 0644)).To(Succeed())
+			})
+
+			it("passes detection", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan.Provides).To(Equal([]packit.BuildPlanProvision{
+					{Name: poetryinstall.PoetryVenv},
+				}))
+			})
+		})
+
+		context("when BP_POETRY_REQUIRE_LOCK is set", func() {
+			it.Before(func() {
+				Expect(os.Setenv("BP_POETRY_REQUIRE_LOCK", "true")).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.Unsetenv("BP_POETRY_REQUIRE_LOCK")).To(Succeed())
+			})
+
+			it("passes detection when there is a poetry.lock", func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte{}, 0644)).To(Succeed())
+
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns an error when there is no poetry.lock", func() {
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).To(MatchError("no 'poetry.lock' found, but BP_POETRY_REQUIRE_LOCK is set: run 'poetry lock' and commit poetry.lock"))
+			})
+
+			it("returns an error when BP_POETRY_REQUIRE_LOCK is not a boolean", func() {
+				Expect(os.Setenv("BP_POETRY_REQUIRE_LOCK", "sometimes")).To(Succeed())
+
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).To(MatchError(ContainSubstring("failed to parse BP_POETRY_REQUIRE_LOCK value 'sometimes'")))
+			})
+		})
+
 		context("failure cases", func() {
+			context("when the pyproject.toml file is malformed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "pyproject.toml"), []byte("%%%"), 0644)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).To(MatchError(ContainSubstring("failed to parse pyproject.toml")))
+				})
+			})
+
 			context("when the pyproject.toml file cannot be read", func() {
 				it.Before(func() {
 					Expect(os.Chmod(workingDir, 0000)).To(Succeed())
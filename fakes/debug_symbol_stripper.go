@@ -0,0 +1,35 @@
+package fakes
+
+import (
+	"sync"
+
+	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+)
+
+type DebugSymbolStripper struct {
+	StripCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			PythonPath string
+			Strict     bool
+		}
+		Returns struct {
+			StripResult poetryinstall.StripResult
+			Error       error
+		}
+		Stub func(string, bool) (poetryinstall.StripResult, error)
+	}
+}
+
+func (f *DebugSymbolStripper) Strip(param1 string, param2 bool) (poetryinstall.StripResult, error) {
+	f.StripCall.mutex.Lock()
+	defer f.StripCall.mutex.Unlock()
+	f.StripCall.CallCount++
+	f.StripCall.Receives.PythonPath = param1
+	f.StripCall.Receives.Strict = param2
+	if f.StripCall.Stub != nil {
+		return f.StripCall.Stub(param1, param2)
+	}
+	return f.StripCall.Returns.StripResult, f.StripCall.Returns.Error
+}
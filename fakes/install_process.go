@@ -1,33 +1,41 @@
 package fakes
 
-import "sync"
+import (
+	"sync"
+
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
 
 type InstallProcess struct {
 	ExecuteCall struct {
 		mutex     sync.Mutex
 		CallCount int
 		Receives  struct {
-			WorkingDir string
-			TargetDir  string
-			CacheDir   string
+			WorkingDir  string
+			TargetDir   string
+			CacheDir    string
+			ExtraGroups []string
+			Bindings    []servicebindings.Binding
 		}
 		Returns struct {
 			String string
 			Error  error
 		}
-		Stub func(string, string, string) (string, error)
+		Stub func(string, string, string, []string, []servicebindings.Binding) (string, error)
 	}
 }
 
-func (f *InstallProcess) Execute(param1 string, param2 string, param3 string) (string, error) {
+func (f *InstallProcess) Execute(param1 string, param2 string, param3 string, param4 []string, param5 []servicebindings.Binding) (string, error) {
 	f.ExecuteCall.mutex.Lock()
 	defer f.ExecuteCall.mutex.Unlock()
 	f.ExecuteCall.CallCount++
 	f.ExecuteCall.Receives.WorkingDir = param1
 	f.ExecuteCall.Receives.TargetDir = param2
 	f.ExecuteCall.Receives.CacheDir = param3
+	f.ExecuteCall.Receives.ExtraGroups = param4
+	f.ExecuteCall.Receives.Bindings = param5
 	if f.ExecuteCall.Stub != nil {
-		return f.ExecuteCall.Stub(param1, param2, param3)
+		return f.ExecuteCall.Stub(param1, param2, param3, param4, param5)
 	}
 	return f.ExecuteCall.Returns.String, f.ExecuteCall.Returns.Error
 }
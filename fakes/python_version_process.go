@@ -0,0 +1,25 @@
+package fakes
+
+import "sync"
+
+type PythonVersionLookupProcess struct {
+	ExecuteCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Returns   struct {
+			String string
+			Error  error
+		}
+		Stub func() (string, error)
+	}
+}
+
+func (f *PythonVersionLookupProcess) Execute() (string, error) {
+	f.ExecuteCall.mutex.Lock()
+	defer f.ExecuteCall.mutex.Unlock()
+	f.ExecuteCall.CallCount++
+	if f.ExecuteCall.Stub != nil {
+		return f.ExecuteCall.Stub()
+	}
+	return f.ExecuteCall.Returns.String, f.ExecuteCall.Returns.Error
+}
@@ -11,23 +11,25 @@ type SBOMGenerator struct {
 		mutex     sync.Mutex
 		CallCount int
 		Receives  struct {
-			Dir string
+			WorkingDir      string
+			SitePackagesDir string
 		}
 		Returns struct {
 			SBOM  sbom.SBOM
 			Error error
 		}
-		Stub func(string) (sbom.SBOM, error)
+		Stub func(string, string) (sbom.SBOM, error)
 	}
 }
 
-func (f *SBOMGenerator) Generate(param1 string) (sbom.SBOM, error) {
+func (f *SBOMGenerator) Generate(param1 string, param2 string) (sbom.SBOM, error) {
 	f.GenerateCall.mutex.Lock()
 	defer f.GenerateCall.mutex.Unlock()
 	f.GenerateCall.CallCount++
-	f.GenerateCall.Receives.Dir = param1
+	f.GenerateCall.Receives.WorkingDir = param1
+	f.GenerateCall.Receives.SitePackagesDir = param2
 	if f.GenerateCall.Stub != nil {
-		return f.GenerateCall.Stub(param1)
+		return f.GenerateCall.Stub(param1, param2)
 	}
 	return f.GenerateCall.Returns.SBOM, f.GenerateCall.Returns.Error
 }
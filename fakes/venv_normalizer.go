@@ -0,0 +1,30 @@
+package fakes
+
+import "sync"
+
+type VenvNormalizer struct {
+	NormalizeCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			WorkingDir string
+			VenvDir    string
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(string, string) error
+	}
+}
+
+func (f *VenvNormalizer) Normalize(param1 string, param2 string) error {
+	f.NormalizeCall.mutex.Lock()
+	defer f.NormalizeCall.mutex.Unlock()
+	f.NormalizeCall.CallCount++
+	f.NormalizeCall.Receives.WorkingDir = param1
+	f.NormalizeCall.Receives.VenvDir = param2
+	if f.NormalizeCall.Stub != nil {
+		return f.NormalizeCall.Stub(param1, param2)
+	}
+	return f.NormalizeCall.Returns.Error
+}
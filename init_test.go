@@ -11,7 +11,11 @@ func TestUnitPoetryInstall(t *testing.T) {
 	suite := spec.New("poetryinstall", spec.Report(report.Terminal{}))
 	suite("Detect", testDetect)
 	suite("Build", testBuild)
+	suite("DebugSymbolStripper", testDebugSymbolStripper)
 	suite("InstallProcess", testInstallProcess)
 	suite("PythonPathProcess", testPythonPathProcess)
+	suite("PythonVersionProcess", testPythonVersionProcess)
+	suite("SBOMGenerator", testSBOMGenerator)
+	suite("VenvNormalizer", testVenvNormalizer)
 	suite.Run(t)
 }
@@ -0,0 +1,153 @@
+package poetryinstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// installGroups holds the dependency group selection configured by
+// BP_POETRY_INSTALL_ONLY, BP_POETRY_INSTALL_WITH, BP_POETRY_INSTALL_WITHOUT
+// and BP_POETRY_INSTALL_ALL_GROUPS. Build holds the build-time groups that are
+// installed on top of that selection into the build-only venv layer.
+type installGroups struct {
+	Only    []string
+	With    []string
+	Without []string
+	All     bool
+	Build   []string
+}
+
+// loadInstallGroups reads the dependency group selection from the
+// environment and rejects combinations that conflict.
+func loadInstallGroups() (installGroups, error) {
+	groups := installGroups{
+		Only:    splitList(os.Getenv("BP_POETRY_INSTALL_ONLY")),
+		With:    splitList(os.Getenv("BP_POETRY_INSTALL_WITH")),
+		Without: splitList(os.Getenv("BP_POETRY_INSTALL_WITHOUT")),
+	}
+
+	if value, exists := os.LookupEnv("BP_POETRY_INSTALL_ALL_GROUPS"); exists {
+		var err error
+		groups.All, err = strconv.ParseBool(value)
+		if err != nil {
+			return installGroups{}, fmt.Errorf("failed to parse BP_POETRY_INSTALL_ALL_GROUPS value '%s': %w", value, err)
+		}
+	}
+
+	if groups.All && len(groups.Only) > 0 {
+		return installGroups{}, errors.New("BP_POETRY_INSTALL_ONLY cannot be combined with BP_POETRY_INSTALL_ALL_GROUPS")
+	}
+
+	if groups.All && len(groups.With) > 0 {
+		return installGroups{}, errors.New("BP_POETRY_INSTALL_WITH cannot be combined with BP_POETRY_INSTALL_ALL_GROUPS")
+	}
+
+	for _, group := range groups.With {
+		if slices.Contains(groups.Without, group) {
+			return installGroups{}, fmt.Errorf("group '%s' cannot be set in both BP_POETRY_INSTALL_WITH and BP_POETRY_INSTALL_WITHOUT", group)
+		}
+	}
+
+	return groups, nil
+}
+
+// loadBuildGroups reads the build-time groups configured by
+// BP_POETRY_INSTALL_BUILD_GROUPS.
+func loadBuildGroups() []string {
+	return splitList(os.Getenv("BP_POETRY_INSTALL_BUILD_GROUPS"))
+}
+
+// including returns a copy of the selection that also installs the given
+// build-time groups, even when they are excluded by BP_POETRY_INSTALL_WITHOUT.
+func (g installGroups) including(groups []string) installGroups {
+	g.Build = appendUnique(append([]string{}, g.Build...), groups...)
+	return g
+}
+
+// String returns a canonical description of the selection that does not
+// depend on the groups declared in pyproject.toml.
+func (g installGroups) String() string {
+	base := g.Only
+	if g.All {
+		base = []string{":all:"}
+	} else if len(base) == 0 {
+		base = []string{"main"}
+	}
+
+	selection := appendUnique(nil, base...)
+	selection = appendUnique(selection, g.With...)
+	selection = appendUnique(selection, g.Build...)
+	for _, group := range g.Without {
+		if !slices.Contains(g.Build, group) {
+			selection = append(selection, "-"+group)
+		}
+	}
+
+	return strings.Join(selection, ",")
+}
+
+// resolve validates the selection against the declared groups and returns
+// the groups that will be installed.
+func (g installGroups) resolve(declared []string) ([]string, error) {
+	for _, setting := range []struct {
+		name   string
+		groups []string
+	}{
+		{"BP_POETRY_INSTALL_ONLY", g.Only},
+		{"BP_POETRY_INSTALL_WITH", g.With},
+		{"BP_POETRY_INSTALL_WITHOUT", g.Without},
+		{"BP_POETRY_INSTALL_BUILD_GROUPS", g.Build},
+	} {
+		for _, group := range setting.groups {
+			if !slices.Contains(declared, group) {
+				return nil, fmt.Errorf("group '%s' from %s is not declared in pyproject.toml, valid groups are: [%s]", group, setting.name, strings.Join(declared, ", "))
+			}
+		}
+	}
+
+	base := g.Only
+	if g.All {
+		base = declared
+	} else if len(base) == 0 {
+		base = []string{"main"}
+	}
+
+	var resolved []string
+	for _, group := range appendUnique(appendUnique(nil, base...), g.With...) {
+		if !slices.Contains(g.Without, group) {
+			resolved = append(resolved, group)
+		}
+	}
+	resolved = appendUnique(resolved, g.Build...)
+
+	if len(resolved) == 0 {
+		return nil, errors.New("no dependency groups selected for installation")
+	}
+
+	return resolved, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
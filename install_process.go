@@ -2,64 +2,470 @@ package poetryinstall
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
 )
 
 //go:generate faux --interface Executable --output fakes/executable.go
 
+var poetryVersionPattern = regexp.MustCompile(`version (\d+\.\d+(?:\.\d+)?)`)
+
 // Executable defines the interface for invoking an executable.
 type Executable interface {
 	Execute(pexec.Execution) error
 }
 
+// defaultSourceDateEpoch is the timestamp used for reproducible bytecode when
+// SOURCE_DATE_EPOCH is not set, the same 1980-01-01 00:00:01 that the
+// lifecycle sets on the files of the app image.
+const defaultSourceDateEpoch = "315532801"
+
 // PoetryInstallProcess implements the InstallProcess interface.
 type PoetryInstallProcess struct {
-	executable Executable
-	logger     scribe.Emitter
+	executable        Executable
+	pythonPathProcess PythonPathLookupProcess
+	logger            scribe.Emitter
 }
 
-// NewPoetryInstallProcess creates an instance of the PoetryInstallProcess given an Executable.
-func NewPoetryInstallProcess(executable Executable, logger scribe.Emitter) PoetryInstallProcess {
+// NewPoetryInstallProcess creates an instance of the PoetryInstallProcess
+// given an Executable that invokes poetry, and a PythonPathLookupProcess that
+// finds the site-packages directory of the virtual env.
+func NewPoetryInstallProcess(executable Executable, pythonPathProcess PythonPathLookupProcess, logger scribe.Emitter) PoetryInstallProcess {
 	return PoetryInstallProcess{
-		executable: executable,
-		logger:     logger,
+		executable:        executable,
+		pythonPathProcess: pythonPathProcess,
+		logger:            logger,
 	}
 }
 
 // Execute installs the poetry dependencies from workingDir/pyproject.toml into
-// a virtual env in the targetPath.
-func (p PoetryInstallProcess) Execute(workingDir, targetPath, cachePath string) (string, error) {
-	installOnly, exists := os.LookupEnv("BP_POETRY_INSTALL_ONLY")
-	if !exists {
-		installOnly = "main"
+// a virtual env in the targetPath, even when poetry is configured to create
+// the virtual env in the project. The extraGroups are installed on top of
+// the configured group selection. The credentials, certificates and source
+// mirrors provided by the bindings are only passed to the install command.
+// When BP_POETRY_COMPILE_BYTECODE is set, the installed modules are compiled
+// to bytecode that does not depend on the time of the build.
+func (p PoetryInstallProcess) Execute(workingDir, targetPath, cachePath string, extraGroups []string, bindings []servicebindings.Binding) (string, error) {
+	groups, err := loadInstallGroups()
+	if err != nil {
+		return "", err
 	}
-	poetryVersion, exists := os.LookupEnv("BP_POETRY_VERSION")
-	if !exists {
-		poetryVersion = "2.*"
+	groups = groups.including(extraGroups)
+
+	extras, allExtras, err := installExtras()
+	if err != nil {
+		return "", err
+	}
+
+	pyProject, err := ParsePyProject(filepath.Join(workingDir, "pyproject.toml"))
+	if err != nil {
+		return "", err
+	}
+
+	poetryVersion, err := p.poetryVersion(workingDir)
+	if err != nil {
+		return "", err
+	}
+
+	capabilities, err := lookupPoetryCapabilities(poetryVersion)
+	if err != nil {
+		return "", err
+	}
+
+	args := append([]string{}, capabilities.syncCommand...)
+
+	resolvedGroups, err := groups.resolve(pyProject.Groups())
+	if err != nil {
+		return "", err
+	}
+
+	if groups.All && len(groups.Without) == 0 && capabilities.allGroups {
+		args = append(args, "--all-groups")
+	} else {
+		args = append(args, "--only", strings.Join(resolvedGroups, ","))
+	}
+
+	compile, err := compileBytecode()
+	if err != nil {
+		return "", err
+	}
+
+	if compile && capabilities.compile {
+		args = append(args, "--compile")
+	}
+
+	if groups.All || len(groups.With) > 0 || len(groups.Without) > 0 || len(groups.Build) > 0 {
+		p.logger.Subprocess("Installing groups: [%s]", strings.Join(resolvedGroups, ", "))
+	}
+
+	root, rootSet, err := installRoot()
+	if err != nil {
+		return "", err
 	}
-	installCmd := []string{"sync"}
-	// Can be remove once support for poetry v1 is removed
-	if strings.HasPrefix(poetryVersion, "1") {
-		installCmd = []string{"install", "--sync"}
+
+	switch {
+	case rootSet && !root:
+		args = append(args, "--no-root")
+		p.logger.Subprocess("Skipping installation of the root project (BP_POETRY_INSTALL_ROOT=false)")
+	case !pyProject.PackageMode():
+		if rootSet {
+			return "", errors.New("BP_POETRY_INSTALL_ROOT is set to true, but pyproject.toml sets package-mode = false")
+		}
+		p.logger.Subprocess("Skipping installation of the root project (package-mode = false)")
+	default:
+		if name := pyProject.Name(); name != "" {
+			p.logger.Subprocess("Installing root project '%s'", name)
+		} else {
+			p.logger.Subprocess("Installing root project")
+		}
+		if scripts := pyProject.Scripts(); len(scripts) > 0 {
+			p.logger.Subprocess("Installing console scripts: [%s]", strings.Join(scripts, ", "))
+		}
+	}
+
+	if allExtras || len(extras) > 0 {
+		declared := pyProject.Extras()
+		if allExtras {
+			args = append(args, "--all-extras")
+			extras = declared
+		}
+
+		for _, extra := range extras {
+			if !slices.Contains(declared, extra) {
+				return "", fmt.Errorf("extra '%s' from BP_POETRY_INSTALL_EXTRAS is not declared in pyproject.toml, declared extras are: [%s]", extra, strings.Join(declared, ", "))
+			}
+
+			if !allExtras {
+				args = append(args, "--extras", extra)
+			}
+		}
+
+		p.logger.Subprocess("Installing extras: [%s]", strings.Join(extras, ", "))
+	}
+
+	mirrors, err := loadSourceMirrors(bindings, pyProject)
+	if err != nil {
+		return "", err
+	}
+
+	sources := pyProject.Sources()
+	if slices.ContainsFunc(mirrors, func(mirror sourceMirror) bool { return mirror.Source == "pypi" }) {
+		sources = append(sources, PyPIMirrorSource)
+	}
+
+	config, err := loadPoetryConfig(sources)
+	if err != nil {
+		return "", err
+	}
+
+	if len(config) > 0 {
+		p.logger.Subprocess("Poetry configuration:")
+		for _, setting := range config {
+			p.logger.Action("%s", setting)
+		}
 	}
 
-	args := append(installCmd, "--only", installOnly)
+	var configEnv []string
+	for _, setting := range config {
+		configEnv = append(configEnv, setting.Env())
+	}
+
+	if _, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); compile && !ok {
+		configEnv = append(configEnv, fmt.Sprintf("SOURCE_DATE_EPOCH=%s", defaultSourceDateEpoch))
+	}
+
+	lockPolicy, err := loadLockPolicy()
+	if err != nil {
+		return "", err
+	}
+
+	hasLock, err := fs.Exists(filepath.Join(workingDir, "poetry.lock"))
+	if err != nil {
+		return "", err
+	}
+
+	if wheelhouse := wheelhouseDir(workingDir); wheelhouse != "" {
+		if lockPolicy == LockPolicyRelock {
+			return "", errors.New("BP_POETRY_LOCK_POLICY=relock cannot be combined with BP_POETRY_WHEELHOUSE, as relocking requires access to the package sources")
+		}
+
+		// pip installs the locked packages whether or not poetry.lock is
+		// consistent with pyproject.toml.
+		capabilities.installsStaleLock = true
+
+		if hasLock {
+			err = p.checkLock(workingDir, capabilities, lockPolicy, append(os.Environ(), configEnv...))
+			if err != nil {
+				return "", err
+			}
+		}
+
+		installProjectRoot := (!rootSet || root) && pyProject.PackageMode()
+		return p.installFromWheelhouse(workingDir, targetPath, cachePath, wheelhouse, pyProject, resolvedGroups, extras, installProjectRoot, configEnv)
+	}
+
+	if !hasLock && len(mirrors) > 0 {
+		return "", errors.New("no 'poetry.lock' found, but source mirrors are configured, and generating it would bypass the mirrors: run 'poetry lock' and commit poetry.lock")
+	}
+
+	if lockPolicy == LockPolicyRelock && len(mirrors) > 0 {
+		return "", errors.New("BP_POETRY_LOCK_POLICY=relock cannot be combined with source mirrors, as poetry.lock would be updated from the sources declared in pyproject.toml rather than the mirrors")
+	}
+
+	credentials, credentialSources, err := sourceCredentials(bindings, sources)
+	if err != nil {
+		return "", err
+	}
+
+	if len(credentialSources) > 0 {
+		p.logger.Subprocess("Using credentials from service bindings for sources: [%s]", strings.Join(credentialSources, ", "))
+	}
+
+	certificatesDir, err := os.MkdirTemp("", "poetry-certificates")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(certificatesDir)
+
+	certificates, err := loadSourceCertificates(bindings, sources, certificatesDir)
+	if err != nil {
+		return "", err
+	}
+
+	if certificates.CABundle != "" {
+		p.logger.Subprocess("Using additional CA certificates for all sources")
+	}
+
+	if certificateSources := certificates.Sources(); len(certificateSources) > 0 {
+		p.logger.Subprocess("Using certificates for sources: [%s]", strings.Join(certificateSources, ", "))
+	}
 
 	env := append(
 		os.Environ(),
 		fmt.Sprintf("POETRY_CACHE_DIR=%s", cachePath),
 		fmt.Sprintf("POETRY_VIRTUALENVS_PATH=%s", targetPath),
+		"POETRY_VIRTUALENVS_IN_PROJECT=false",
 	)
+	env = append(env, configEnv...)
+	env = append(env, credentials...)
+	env = append(env, certificates.Env(sources)...)
+
+	if hasLock {
+		err = p.checkLock(workingDir, capabilities, lockPolicy, env)
+	} else {
+		err = p.generateLock(workingDir, env)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var venvDir string
+	if len(mirrors) > 0 {
+		p.logger.Subprocess("Using mirrors for sources:")
+		for _, mirror := range mirrors {
+			p.logger.Action("%s", mirror)
+		}
+
+		installProjectRoot := (!rootSet || root) && pyProject.PackageMode()
+		venvDir, err = p.installFromMirrors(workingDir, targetPath, cachePath, mirrors, capabilities, args, installProjectRoot, env)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		p.logger.Subprocess(fmt.Sprintf("Running 'POETRY_CACHE_DIR=%s POETRY_VIRTUALENVS_PATH=%s poetry %s'", cachePath, targetPath, strings.Join(args, " ")))
+
+		err = p.executable.Execute(pexec.Execution{
+			Args:   args,
+			Env:    env,
+			Dir:    workingDir,
+			Stdout: p.logger.ActionWriter,
+			Stderr: p.logger.ActionWriter,
+		})
+		if err != nil {
+			return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+		}
+
+		venvDir, err = p.findVenvDir(workingDir, targetPath, cachePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if compile && !capabilities.compile {
+		err = p.compileSitePackages(workingDir, venvDir, env)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return venvDir, nil
+}
+
+// installFromMirrors installs the dependencies of the project in workingDir
+// from the mirrors of its sources, without changing the files of the app. The
+// install runs in a copy of the project with the mirrored sources, where
+// poetry updates the content-hash of poetry.lock. The updated poetry.lock must
+// lock the same packages with the same hashes and pass the lock check. The
+// virtual env is the one poetry uses for workingDir, into which the root
+// project is installed from workingDir, as the copy is removed afterwards.
+func (p PoetryInstallProcess) installFromMirrors(workingDir, targetPath, cachePath string, mirrors []sourceMirror, capabilities poetryCapabilities, args []string, root bool, env []string) (string, error) {
+	projectDir, err := mirroredProject(workingDir, mirrors, capabilities)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(projectDir)
+
+	run := func(dir string, args, env []string) error {
+		p.logger.Subprocess(fmt.Sprintf("Running 'POETRY_CACHE_DIR=%s POETRY_VIRTUALENVS_PATH=%s poetry %s'", cachePath, targetPath, strings.Join(args, " ")))
+
+		return p.executable.Execute(pexec.Execution{
+			Args:   args,
+			Env:    env,
+			Dir:    dir,
+			Stdout: p.logger.ActionWriter,
+			Stderr: p.logger.ActionWriter,
+		})
+	}
+
+	err = run(workingDir, []string{"env", "use", "python"}, env)
+	if err != nil {
+		return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+	}
+
+	venvDir, err := p.findVenvDir(workingDir, targetPath, cachePath)
+	if err != nil {
+		return "", err
+	}
+
+	mirroredEnv := append(append([]string{}, env...), fmt.Sprintf("VIRTUAL_ENV=%s", venvDir))
+
+	p.logger.Subprocess("Updating the content-hash of poetry.lock for the mirrored sources")
+	err = run(projectDir, capabilities.relockCommand, mirroredEnv)
+	if err != nil {
+		return "", fmt.Errorf("failed to lock the dependencies with the mirrored sources:\nerror: %w", err)
+	}
+
+	original, err := ParsePoetryLock(filepath.Join(workingDir, "poetry.lock"))
+	if err != nil {
+		return "", err
+	}
+
+	mirrored, err := ParsePoetryLock(filepath.Join(projectDir, "poetry.lock"))
+	if err != nil {
+		return "", err
+	}
+
+	err = verifyMirroredLock(original, mirrored)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = p.executable.Execute(pexec.Execution{
+		Args:   capabilities.lockCheckCommand,
+		Env:    mirroredEnv,
+		Dir:    projectDir,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return "", fmt.Errorf("poetry.lock is not consistent with pyproject.toml once its sources are mirrored:\n%s\nerror: %w", strings.TrimSpace(buffer.String()), err)
+	}
+
+	if !slices.Contains(args, "--no-root") {
+		args = append(append([]string{}, args...), "--no-root")
+	}
+
+	err = run(projectDir, args, mirroredEnv)
+	if err != nil {
+		return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+	}
+
+	if root {
+		err = run(workingDir, []string{"install", "--only-root"}, mirroredEnv)
+		if err != nil {
+			return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+		}
+	}
 
-	p.logger.Subprocess(fmt.Sprintf("Running 'POETRY_CACHE_DIR=%s POETRY_VIRTUALENVS_PATH=%s poetry %s'", cachePath, targetPath, strings.Join(args, " ")))
+	return venvDir, nil
+}
 
+// checkLock verifies that the poetry.lock in workingDir is consistent with
+// pyproject.toml. An inconsistent poetry.lock is handled according to the
+// lock policy: the build fails, a warning is logged, or poetry.lock is updated
+// in workingDir. The warning is only logged when the capabilities allow an
+// inconsistent poetry.lock to be installed.
+func (p PoetryInstallProcess) checkLock(workingDir string, capabilities poetryCapabilities, policy string, env []string) error {
+	buffer := bytes.NewBuffer(nil)
 	err := p.executable.Execute(pexec.Execution{
+		Args:   capabilities.lockCheckCommand,
+		Env:    env,
+		Dir:    workingDir,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err == nil {
+		return nil
+	}
+
+	switch policy {
+	case LockPolicyWarn:
+		if !capabilities.installsStaleLock {
+			return fmt.Errorf("poetry.lock is not consistent with pyproject.toml, and BP_POETRY_LOCK_POLICY=warn is not possible as poetry versions '%s' refuse to install it: run 'poetry lock' and commit the updated poetry.lock, or set BP_POETRY_LOCK_POLICY to 'relock'\n%s\nerror: %w", capabilities.constraint, strings.TrimSpace(buffer.String()), err)
+		}
+
+		p.logger.Subprocess("Warning: poetry.lock is not consistent with pyproject.toml, run 'poetry lock' to update it:")
+		p.logger.Action("%s", strings.TrimSpace(buffer.String()))
+		return nil
+
+	case LockPolicyRelock:
+		p.logger.Subprocess("Updating poetry.lock, as it is not consistent with pyproject.toml")
+		p.logger.Subprocess(fmt.Sprintf("Running 'poetry %s'", strings.Join(capabilities.relockCommand, " ")))
+
+		err = p.executable.Execute(pexec.Execution{
+			Args:   capabilities.relockCommand,
+			Env:    env,
+			Dir:    workingDir,
+			Stdout: p.logger.ActionWriter,
+			Stderr: p.logger.ActionWriter,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update poetry.lock:\nerror: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("poetry.lock is not consistent with pyproject.toml: run 'poetry lock' and commit the updated poetry.lock, or set BP_POETRY_LOCK_POLICY to 'warn' or 'relock'\n%s\nerror: %w", strings.TrimSpace(buffer.String()), err)
+}
+
+// compileSitePackages compiles the modules in the site-packages directories
+// of the virtual env at venvDir to bytecode, for poetry versions that cannot
+// compile them as part of the install.
+func (p PoetryInstallProcess) compileSitePackages(workingDir, venvDir string, env []string) error {
+	pythonPath, err := p.pythonPathProcess.Execute(venvDir)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"run", "python", "-m", "compileall", "-q"}, filepath.SplitList(pythonPath)...)
+
+	p.logger.Subprocess("Compiling bytecode")
+	p.logger.Subprocess(fmt.Sprintf("Running 'poetry %s'", strings.Join(args, " ")))
+
+	err = p.executable.Execute(pexec.Execution{
 		Args:   args,
 		Env:    env,
 		Dir:    workingDir,
@@ -67,7 +473,155 @@ func (p PoetryInstallProcess) Execute(workingDir, targetPath, cachePath string)
 		Stderr: p.logger.ActionWriter,
 	})
 	if err != nil {
-		return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+		return fmt.Errorf("failed to compile bytecode:\nerror: %w", err)
+	}
+
+	return nil
+}
+
+// generateLock resolves the dependencies of the project in workingDir into a
+// new poetry.lock and logs the resolved versions.
+func (p PoetryInstallProcess) generateLock(workingDir string, env []string) error {
+	p.logger.Subprocess("Generating poetry.lock, as the project has none")
+	p.logger.Subprocess("Running 'poetry lock'")
+
+	err := p.executable.Execute(pexec.Execution{
+		Args:   []string{"lock"},
+		Env:    env,
+		Dir:    workingDir,
+		Stdout: p.logger.ActionWriter,
+		Stderr: p.logger.ActionWriter,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate poetry.lock:\nerror: %w", err)
+	}
+
+	lock, err := ParsePoetryLock(filepath.Join(workingDir, "poetry.lock"))
+	if err != nil {
+		return err
+	}
+
+	p.logger.Subprocess("Resolved packages:")
+	for _, lockPackage := range lock.Packages {
+		p.logger.Action("%s==%s", lockPackage.Name, lockPackage.Version)
+	}
+
+	return nil
+}
+
+// poetryVersion returns the version of the poetry executable that performs
+// the installation.
+func (p PoetryInstallProcess) poetryVersion(workingDir string) (*semver.Version, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := p.executable.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Dir:    workingDir,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up poetry version:\n%s\nerror: %w", buffer, err)
+	}
+
+	// The output looks like "Poetry (version 2.1.1)"
+	matches := poetryVersionPattern.FindStringSubmatch(buffer.String())
+	if matches == nil {
+		return nil, fmt.Errorf("failed to parse poetry version from output: '%s'", strings.TrimSpace(buffer.String()))
+	}
+
+	version, err := semver.NewVersion(matches[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse poetry version from output: '%s'", strings.TrimSpace(buffer.String()))
+	}
+
+	return version, nil
+}
+
+// installFromWheelhouse installs the locked packages of the given groups and
+// extras from the distributions in the wheelhouse directory, without access
+// to any package index. Poetry creates the virtual env, and the packages are
+// installed into it by pip, which verifies them against the hashes in
+// poetry.lock. As pip never removes packages, the virtual env of a previous
+// build in targetPath is removed first, so that packages that are no longer
+// locked are not kept. The config holds the environment variables of the
+// configured poetry settings.
+func (p PoetryInstallProcess) installFromWheelhouse(workingDir, targetPath, cachePath, wheelhouse string, pyProject PyProject, groups, extras []string, root bool, config []string) (string, error) {
+	p.logger.Subprocess("Installing from the wheelhouse '%s' without network access", wheelhouse)
+
+	lock, err := ParsePoetryLock(filepath.Join(workingDir, "poetry.lock"))
+	if err != nil {
+		return "", fmt.Errorf("installing from a wheelhouse requires a poetry.lock:\nerror: %w", err)
+	}
+
+	requirements, err := wheelhouseRequirements(lock, pyProject, wheelhouse, groups, extras)
+	if err != nil {
+		return "", err
+	}
+
+	requirementsFile, err := os.CreateTemp("", "requirements-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(requirementsFile.Name())
+
+	_, err = requirementsFile.WriteString(strings.Join(requirements, "\n") + "\n")
+	if err != nil {
+		return "", err
+	}
+
+	err = requirementsFile.Close()
+	if err != nil {
+		return "", err
+	}
+
+	previous, err := os.ReadDir(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if len(previous) > 0 {
+		p.logger.Subprocess("Removing the virtual env of the previous build, as pip does not remove packages that are no longer locked")
+		for _, entry := range previous {
+			if err := os.RemoveAll(filepath.Join(targetPath, entry.Name())); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	env := append(
+		os.Environ(),
+		fmt.Sprintf("POETRY_CACHE_DIR=%s", cachePath),
+		fmt.Sprintf("POETRY_VIRTUALENVS_PATH=%s", targetPath),
+		"POETRY_VIRTUALENVS_IN_PROJECT=false",
+		"PIP_NO_INDEX=1",
+		fmt.Sprintf("PIP_FIND_LINKS=%s", wheelhouse),
+		"PIP_DISABLE_PIP_VERSION_CHECK=1",
+	)
+	env = append(env, config...)
+
+	pipInstall := []string{"run", "python", "-m", "pip", "install", "--no-index", "--find-links", wheelhouse, "--no-deps"}
+	commands := [][]string{
+		{"env", "use", "python"},
+		append(append([]string{}, pipInstall...), "--require-hashes", "--requirement", requirementsFile.Name()),
+	}
+
+	if root {
+		commands = append(commands, append(append([]string{}, pipInstall...), workingDir))
+	}
+
+	for _, args := range commands {
+		p.logger.Subprocess(fmt.Sprintf("Running 'POETRY_CACHE_DIR=%s POETRY_VIRTUALENVS_PATH=%s poetry %s'", cachePath, targetPath, strings.Join(args, " ")))
+
+		err = p.executable.Execute(pexec.Execution{
+			Args:   args,
+			Env:    env,
+			Dir:    workingDir,
+			Stdout: p.logger.ActionWriter,
+			Stderr: p.logger.ActionWriter,
+		})
+		if err != nil {
+			return "", fmt.Errorf("poetry install failed:\nerror: %w", err)
+		}
 	}
 
 	return p.findVenvDir(workingDir, targetPath, cachePath)
@@ -78,6 +632,7 @@ func (p PoetryInstallProcess) findVenvDir(workingDir, targetPath, cachePath stri
 		os.Environ(),
 		fmt.Sprintf("POETRY_CACHE_DIR=%s", cachePath),
 		fmt.Sprintf("POETRY_VIRTUALENVS_PATH=%s", targetPath),
+		"POETRY_VIRTUALENVS_IN_PROJECT=false",
 	)
 
 	args := []string{"env", "info", "--path"}
@@ -97,3 +652,93 @@ func (p PoetryInstallProcess) findVenvDir(workingDir, targetPath, cachePath stri
 
 	return filepath.Clean(strings.TrimSpace(outBuffer.String())), nil
 }
+
+// installExtras returns the extras that are installed into the virtual env, as
+// configured by BP_POETRY_INSTALL_EXTRAS and BP_POETRY_INSTALL_ALL_EXTRAS.
+func installExtras() ([]string, bool, error) {
+	var extras []string
+	for _, extra := range splitList(os.Getenv("BP_POETRY_INSTALL_EXTRAS")) {
+		extras = append(extras, normalizeName(extra))
+	}
+
+	var allExtras bool
+	if value, exists := os.LookupEnv("BP_POETRY_INSTALL_ALL_EXTRAS"); exists {
+		var err error
+		allExtras, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse BP_POETRY_INSTALL_ALL_EXTRAS value '%s': %w", value, err)
+		}
+	}
+
+	if allExtras && len(extras) > 0 {
+		return nil, false, errors.New("BP_POETRY_INSTALL_EXTRAS cannot be combined with BP_POETRY_INSTALL_ALL_EXTRAS")
+	}
+
+	return extras, allExtras, nil
+}
+
+// installRoot returns whether the root project is installed into the virtual
+// env, as configured by BP_POETRY_INSTALL_ROOT, and whether it was configured
+// at all.
+func installRoot() (bool, bool, error) {
+	value, exists := os.LookupEnv("BP_POETRY_INSTALL_ROOT")
+	if !exists {
+		return false, false, nil
+	}
+
+	root, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to parse BP_POETRY_INSTALL_ROOT value '%s': %w", value, err)
+	}
+
+	return root, true, nil
+}
+
+// loadLockPolicy returns how an inconsistent poetry.lock is handled, as
+// configured by BP_POETRY_LOCK_POLICY. The build fails by default.
+func loadLockPolicy() (string, error) {
+	policy, exists := os.LookupEnv("BP_POETRY_LOCK_POLICY")
+	if !exists || policy == "" {
+		return LockPolicyStrict, nil
+	}
+
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if !slices.Contains([]string{LockPolicyStrict, LockPolicyWarn, LockPolicyRelock}, policy) {
+		return "", fmt.Errorf("BP_POETRY_LOCK_POLICY value '%s' is not supported, supported values are: [%s, %s, %s]", policy, LockPolicyStrict, LockPolicyWarn, LockPolicyRelock)
+	}
+
+	return policy, nil
+}
+
+// requireLock returns whether the project must have a poetry.lock, as
+// configured by BP_POETRY_REQUIRE_LOCK. Otherwise a missing poetry.lock is
+// generated during the build.
+func requireLock() (bool, error) {
+	value, exists := os.LookupEnv("BP_POETRY_REQUIRE_LOCK")
+	if !exists {
+		return false, nil
+	}
+
+	required, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse BP_POETRY_REQUIRE_LOCK value '%s': %w", value, err)
+	}
+
+	return required, nil
+}
+
+// compileBytecode returns whether the installed modules are compiled to
+// bytecode, as configured by BP_POETRY_COMPILE_BYTECODE.
+func compileBytecode() (bool, error) {
+	value, exists := os.LookupEnv("BP_POETRY_COMPILE_BYTECODE")
+	if !exists {
+		return false, nil
+	}
+
+	compile, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse BP_POETRY_COMPILE_BYTECODE value '%s': %w", value, err)
+	}
+
+	return compile, nil
+}
@@ -104,5 +104,6 @@ func TestIntegration(t *testing.T) {
 
 	suite := spec.New("Integration", spec.Report(report.Terminal{}))
 	suite("Default", testDefault, spec.Parallel())
+	suite("Reproducible", testReproducible, spec.Parallel())
 	suite.Run(t)
 }
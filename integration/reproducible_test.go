@@ -0,0 +1,91 @@
+package integration_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/occam"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testReproducible(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		pack   occam.Pack
+		docker occam.Docker
+	)
+
+	it.Before(func() {
+		pack = occam.NewPack()
+		docker = occam.NewDocker()
+	})
+
+	context("when the app is built twice", func() {
+		var (
+			names  []string
+			source string
+		)
+
+		it.Before(func() {
+			var err error
+			source, err = occam.Source(filepath.Join("testdata", "default_app"))
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				name, err := occam.RandomName()
+				Expect(err).NotTo(HaveOccurred())
+				names = append(names, name)
+			}
+		})
+
+		it.After(func() {
+			// The images are removed by name, as both builds may give the same
+			// image.
+			for _, name := range names {
+				Expect(docker.Image.Remove.Execute(name)).To(Succeed())
+				Expect(docker.Volume.Remove.Execute(occam.CacheVolumeNames(name))).To(Succeed())
+			}
+
+			Expect(os.RemoveAll(source)).To(Succeed())
+		})
+
+		it("gives the same poetry-venv layer", func() {
+			var digests []string
+			for _, name := range names {
+				image, logs, err := pack.WithNoColor().Build.
+					WithPullPolicy("never").
+					WithEnv(map[string]string{
+						"BP_POETRY_COMPILE_BYTECODE": "true",
+					}).
+					WithBuildpacks(
+						settings.Buildpacks.CPython.Online,
+						settings.Buildpacks.Pip.Online,
+						settings.Buildpacks.Poetry.Online,
+						settings.Buildpacks.PoetryInstall.Online,
+						settings.Buildpacks.BuildPlan.Online,
+					).
+					Execute(name, source)
+				Expect(err).ToNot(HaveOccurred(), logs.String)
+
+				Expect(logs).To(ContainSubstring("Executing build process"))
+
+				var digest string
+				for _, buildpack := range image.Buildpacks {
+					if buildpack.Key == buildpackInfo.Buildpack.ID {
+						digest = buildpack.Layers["poetry-venv"].SHA
+					}
+				}
+				Expect(digest).NotTo(BeEmpty(), fmt.Sprintf("no poetry-venv layer in image %s", image.ID))
+
+				digests = append(digests, digest)
+			}
+
+			Expect(digests[1]).To(Equal(digests[0]))
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// launchProcesses returns a launch process for each console script declared by
+// the project in workingDir, running the script installed into the bin
+// directory of venvDir. The default process is picked by
+// BP_POETRY_DEFAULT_PROCESS, or is the only script when just one is declared.
+// No processes are returned when BP_POETRY_GENERATE_PROCESSES is false or
+// when the root project, and therefore its scripts, is not installed.
+func launchProcesses(workingDir, venvDir string) ([]packit.Process, error) {
+	if value, exists := os.LookupEnv("BP_POETRY_GENERATE_PROCESSES"); exists {
+		generate, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse BP_POETRY_GENERATE_PROCESSES value '%s': %w", value, err)
+		}
+
+		if !generate {
+			return nil, nil
+		}
+	}
+
+	root, rootSet, err := installRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	if rootSet && !root {
+		return nil, nil
+	}
+
+	pyProjectPath := filepath.Join(workingDir, "pyproject.toml")
+	exists, err := fs.Exists(pyProjectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	pyProject, err := ParsePyProject(pyProjectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pyProject.PackageMode() {
+		return nil, nil
+	}
+
+	scripts := pyProject.Scripts()
+
+	defaultProcess := os.Getenv("BP_POETRY_DEFAULT_PROCESS")
+	if defaultProcess != "" && !slices.Contains(scripts, defaultProcess) {
+		return nil, fmt.Errorf("process '%s' from BP_POETRY_DEFAULT_PROCESS is not declared in pyproject.toml, declared scripts are: [%s]", defaultProcess, strings.Join(scripts, ", "))
+	}
+
+	if defaultProcess == "" && len(scripts) == 1 {
+		defaultProcess = scripts[0]
+	}
+
+	var processes []packit.Process
+	for _, script := range scripts {
+		processes = append(processes, packit.Process{
+			Type:    script,
+			Command: filepath.Join(venvDir, "bin", script),
+			Args:    []string{},
+			Direct:  true,
+			Default: script == defaultProcess,
+		})
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,105 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// poetryCapabilities describes the command line supported by a range of
+// poetry versions.
+type poetryCapabilities struct {
+	// constraint is the range of poetry versions the entry applies to.
+	constraint string
+
+	// syncCommand installs the locked dependencies and removes any others
+	// from the virtual env.
+	syncCommand []string
+
+	// lockCheckCommand exits with an error when poetry.lock is not consistent
+	// with pyproject.toml.
+	lockCheckCommand []string
+
+	// relockCommand updates poetry.lock to match pyproject.toml while keeping
+	// the locked versions where possible.
+	relockCommand []string
+
+	// compile denotes whether the sync command accepts --compile.
+	compile bool
+
+	// allGroups denotes whether the sync command accepts --all-groups.
+	allGroups bool
+
+	// installsStaleLock denotes whether the sync command installs from a
+	// poetry.lock that is not consistent with pyproject.toml, with a warning,
+	// rather than failing.
+	installsStaleLock bool
+
+	// pypiMirror holds the settings of a package source that replaces PyPI as
+	// the default source.
+	pypiMirror map[string]interface{}
+
+	// primaryReplacesPyPI denotes whether declaring a primary package source,
+	// which is the default priority, disables PyPI.
+	primaryReplacesPyPI bool
+}
+
+// poetryCapabilityTable lists the supported poetry versions, newest first.
+var poetryCapabilityTable = []poetryCapabilities{
+	{
+		constraint:          ">=2.0.0, <3.0.0",
+		syncCommand:         []string{"sync"},
+		lockCheckCommand:    []string{"check", "--lock"},
+		relockCommand:       []string{"lock"},
+		compile:             true,
+		allGroups:           true,
+		pypiMirror:          map[string]interface{}{"priority": "primary"},
+		primaryReplacesPyPI: true,
+	},
+	{
+		constraint:        ">=1.8.0, <2.0.0",
+		syncCommand:       []string{"install", "--sync"},
+		lockCheckCommand:  []string{"check", "--lock"},
+		relockCommand:     []string{"lock", "--no-update"},
+		compile:           true,
+		installsStaleLock: true,
+		pypiMirror:        map[string]interface{}{"priority": "default"},
+	},
+	{
+		constraint:        ">=1.5.0, <1.8.0",
+		syncCommand:       []string{"install", "--sync"},
+		lockCheckCommand:  []string{"lock", "--check"},
+		relockCommand:     []string{"lock", "--no-update"},
+		installsStaleLock: true,
+		pypiMirror:        map[string]interface{}{"priority": "default"},
+	},
+	{
+		constraint:        ">=1.2.0, <1.5.0",
+		syncCommand:       []string{"install", "--sync"},
+		lockCheckCommand:  []string{"lock", "--check"},
+		relockCommand:     []string{"lock", "--no-update"},
+		installsStaleLock: true,
+		pypiMirror:        map[string]interface{}{"default": true},
+	},
+}
+
+// lookupPoetryCapabilities returns the capabilities of the given poetry
+// version, or an error when the version is not supported.
+func lookupPoetryCapabilities(version *semver.Version) (poetryCapabilities, error) {
+	var supported []string
+	for _, capabilities := range poetryCapabilityTable {
+		constraint, err := semver.NewConstraint(capabilities.constraint)
+		if err != nil {
+			return poetryCapabilities{}, err
+		}
+
+		if constraint.Check(version) {
+			return capabilities, nil
+		}
+
+		supported = append(supported, fmt.Sprintf("'%s'", capabilities.constraint))
+	}
+
+	return poetryCapabilities{}, fmt.Errorf("poetry version '%s' is not supported, supported versions are: %s", version, strings.Join(supported, ", "))
+}
@@ -0,0 +1,190 @@
+package poetryinstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// poetryConfigKeys are the poetry settings that can be configured with
+// BP_POETRY_CONFIG_<KEY> environment variables, where <KEY> is the setting
+// in upper case with dots and dashes replaced by underscores.
+var poetryConfigKeys = []string{
+	"installer.max-workers",
+	"installer.modern-installation",
+	"installer.no-binary",
+	"installer.only-binary",
+	"installer.parallel",
+	"installer.re-resolve",
+	"keyring.enabled",
+	"requests.max-retries",
+	"solver.lazy-wheel",
+	"system-git-client",
+	"virtualenvs.options.always-copy",
+	"virtualenvs.options.no-pip",
+	"virtualenvs.options.system-site-packages",
+	"virtualenvs.prefer-active-python",
+	"virtualenvs.use-poetry-python",
+}
+
+// poetryConfigSourceKeys are the poetry settings of a single source that can
+// be configured, with <source> standing for the name of the source. The
+// values of secret settings are never logged.
+var poetryConfigSourceKeys = map[string]bool{
+	"http-basic.<source>.username": false,
+	"http-basic.<source>.password": true,
+	"pypi-token.<source>":          true,
+}
+
+// poetryConfigSetting is a poetry setting configured by a
+// BP_POETRY_CONFIG_<KEY> environment variable.
+type poetryConfigSetting struct {
+	Key    string
+	Value  string
+	Secret bool
+}
+
+// Env returns the environment variable that configures poetry with the
+// setting.
+func (s poetryConfigSetting) Env() string {
+	return fmt.Sprintf("POETRY_%s=%s", envName(s.Key), s.Value)
+}
+
+// String returns the setting as it is logged, with secret values hidden.
+func (s poetryConfigSetting) String() string {
+	if s.Secret {
+		return fmt.Sprintf("%s = ****", s.Key)
+	}
+
+	return fmt.Sprintf("%s = %s", s.Key, s.Value)
+}
+
+// loadPoetryConfig returns the poetry settings configured by the
+// BP_POETRY_CONFIG_<KEY> environment variables, sorted by key. The settings
+// of the given sources can be configured along with the settings in
+// poetryConfigKeys, any other BP_POETRY_CONFIG_<KEY> is an error.
+func loadPoetryConfig(sources []string) ([]poetryConfigSetting, error) {
+	keys := map[string]string{}
+	secrets := map[string]bool{}
+	for _, key := range poetryConfigKeys {
+		keys[envName(key)] = key
+	}
+
+	for _, source := range sources {
+		for sourceKey, secret := range poetryConfigSourceKeys {
+			key := strings.ReplaceAll(sourceKey, "<source>", source)
+			keys[envName(key)] = key
+			secrets[key] = secret
+		}
+	}
+
+	var settings []poetryConfigSetting
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		name, found := strings.CutPrefix(name, "BP_POETRY_CONFIG_")
+		if !found {
+			continue
+		}
+
+		key, ok := keys[name]
+		if !ok {
+			supported := append([]string{}, poetryConfigKeys...)
+			for sourceKey := range poetryConfigSourceKeys {
+				supported = append(supported, sourceKey)
+			}
+			sort.Strings(supported)
+
+			return nil, fmt.Errorf("BP_POETRY_CONFIG_%s does not configure a supported poetry setting, supported settings are: [%s]", name, strings.Join(supported, ", "))
+		}
+
+		settings = append(settings, poetryConfigSetting{
+			Key:    key,
+			Value:  value,
+			Secret: secrets[key],
+		})
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+
+	return settings, nil
+}
+
+// poetryConfigFingerprint returns the settings in poetryConfigKeys that are
+// configured by BP_POETRY_CONFIG_<KEY> environment variables, as a single
+// string that changes whenever the configuration that affects the installed
+// packages changes. The settings of sources are credentials and are left out.
+func poetryConfigFingerprint() string {
+	var settings []string
+	for _, key := range poetryConfigKeys {
+		if value, ok := os.LookupEnv(fmt.Sprintf("BP_POETRY_CONFIG_%s", envName(key))); ok {
+			settings = append(settings, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
+	return strings.Join(settings, ",")
+}
+
+// virtualenvsInProject reports whether the app asks for its virtual env in
+// the .venv directory of workingDir, as configured by
+// BP_POETRY_VIRTUALENVS_IN_PROJECT or, when that is not set, by the
+// virtualenvs.in-project setting in the poetry.toml of the app.
+func virtualenvsInProject(workingDir string) (bool, error) {
+	if value, ok := os.LookupEnv("BP_POETRY_VIRTUALENVS_IN_PROJECT"); ok {
+		inProject, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse BP_POETRY_VIRTUALENVS_IN_PROJECT value '%s': %w", value, err)
+		}
+
+		return inProject, nil
+	}
+
+	var poetryToml struct {
+		Virtualenvs struct {
+			InProject bool `toml:"in-project"`
+		} `toml:"virtualenvs"`
+	}
+	_, err := toml.DecodeFile(filepath.Join(workingDir, "poetry.toml"), &poetryToml)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to parse poetry.toml:\nerror: %w", err)
+	}
+
+	return poetryToml.Virtualenvs.InProject, nil
+}
+
+// linkInProjectVenv links the .venv directory of workingDir to the virtual
+// env in venvDir. A .venv link from a previous build is replaced, but a .venv
+// directory that is part of the app is an error.
+func linkInProjectVenv(workingDir, venvDir string) error {
+	link := filepath.Join(workingDir, ".venv")
+
+	info, err := os.Lstat(link)
+	if err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("cannot link '%s' to the virtual env, as it already exists in the app: remove it from the app, or set BP_POETRY_VIRTUALENVS_IN_PROJECT to false", link)
+		}
+
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Symlink(venvDir, link)
+	if err != nil {
+		return fmt.Errorf("failed to link '%s' to the virtual env:\nerror: %w", link, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package poetryinstall
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var poetryLockHeaderPattern = regexp.MustCompile(`@generated by Poetry (\d+\.\d+(?:\.\d+)?)`)
+
+// PoetryLock represents the parts of a poetry.lock file that are relevant to
+// the buildpack.
+type PoetryLock struct {
+	// PoetryVersion is the version of Poetry that generated the file, as
+	// recorded in its header. It is empty when the header is missing.
+	PoetryVersion string
+
+	// Packages are the locked packages.
+	Packages []PoetryLockPackage `toml:"package"`
+
+	// Extras maps the extras of the project to the packages they install.
+	Extras map[string][]string `toml:"extras"`
+
+	// Metadata holds the content-hash of the pyproject.toml the file was
+	// generated from.
+	Metadata struct {
+		ContentHash string `toml:"content-hash"`
+	} `toml:"metadata"`
+}
+
+// PoetryLockPackage represents a [[package]] entry of a poetry.lock file.
+type PoetryLockPackage struct {
+	Name    string `toml:"name"`
+	Version string `toml:"version"`
+
+	// Groups are the dependency groups the package belongs to. Lock files
+	// written before Poetry 2.0 record a single Category instead.
+	Groups   []string `toml:"groups"`
+	Category string   `toml:"category"`
+
+	// Optional denotes that the package is only installed for an extra.
+	Optional bool `toml:"optional"`
+
+	// Markers are the environment markers that restrict where the package is
+	// installed, either as a single marker or per group.
+	Markers interface{} `toml:"markers"`
+
+	Dependencies map[string]interface{} `toml:"dependencies"`
+
+	// Extras maps the extras of the package to the requirements of its
+	// optional dependencies that they install.
+	Extras map[string][]string `toml:"extras"`
+
+	Files []struct {
+		File string `toml:"file"`
+		Hash string `toml:"hash"`
+	} `toml:"files"`
+
+	// Source is set for packages that do not come from PyPI.
+	Source struct {
+		Type              string `toml:"type"`
+		URL               string `toml:"url"`
+		Reference         string `toml:"reference"`
+		ResolvedReference string `toml:"resolved_reference"`
+	} `toml:"source"`
+}
+
+// PackageGroups returns the dependency groups the package belongs to.
+func (p PoetryLockPackage) PackageGroups() []string {
+	if len(p.Groups) > 0 {
+		return p.Groups
+	}
+
+	if p.Category != "" {
+		return []string{p.Category}
+	}
+
+	return nil
+}
+
+// Marker returns the environment marker that restricts where the package is
+// installed when any of the given groups is installed, or an empty string
+// when the package is always installed.
+func (p PoetryLockPackage) Marker(groups []string) string {
+	switch markers := p.Markers.(type) {
+	case string:
+		return markers
+	case map[string]interface{}:
+		var alternatives []string
+		for _, group := range p.PackageGroups() {
+			if !slices.Contains(groups, group) {
+				continue
+			}
+
+			marker, ok := markers[group].(string)
+			if !ok {
+				return ""
+			}
+			alternatives = append(alternatives, fmt.Sprintf("(%s)", marker))
+		}
+
+		return strings.Join(alternatives, " or ")
+	}
+
+	return ""
+}
+
+// ParsePoetryLock parses the poetry.lock file at the given path.
+func ParsePoetryLock(path string) (PoetryLock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PoetryLock{}, fmt.Errorf("failed to open poetry.lock:\nerror: %w", err)
+	}
+	defer file.Close()
+
+	var lock PoetryLock
+
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		if matches := poetryLockHeaderPattern.FindStringSubmatch(scanner.Text()); matches != nil {
+			lock.PoetryVersion = matches[1]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return PoetryLock{}, fmt.Errorf("failed to read poetry.lock:\nerror: %w", err)
+	}
+
+	if _, err := toml.DecodeFile(path, &lock); err != nil {
+		return PoetryLock{}, fmt.Errorf("failed to parse poetry.lock:\nerror: %w", err)
+	}
+
+	return lock, nil
+}
@@ -0,0 +1,389 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+)
+
+var nameSeparatorPattern = regexp.MustCompile(`[-_.]+`)
+
+// PoetryCoreBuildBackend is the PEP 517 build backend provided by poetry-core.
+const PoetryCoreBuildBackend = "poetry.core.masonry.api"
+
+// PyProject represents the parts of a pyproject.toml file that are relevant
+// to the buildpack.
+type PyProject struct {
+	BuildSystem struct {
+		Requires     []string `toml:"requires"`
+		BuildBackend string   `toml:"build-backend"`
+	} `toml:"build-system"`
+
+	Project struct {
+		Name                 string              `toml:"name"`
+		RequiresPython       string              `toml:"requires-python"`
+		Dependencies         []string            `toml:"dependencies"`
+		OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+		Scripts              map[string]string   `toml:"scripts"`
+	} `toml:"project"`
+
+	Tool struct {
+		Poetry struct {
+			Name            string                 `toml:"name"`
+			PackageMode     *bool                  `toml:"package-mode"`
+			Scripts         map[string]interface{} `toml:"scripts"`
+			RequiresPoetry  string                 `toml:"requires-poetry"`
+			Dependencies    map[string]interface{} `toml:"dependencies"`
+			DevDependencies map[string]interface{} `toml:"dev-dependencies"`
+			Extras          map[string][]string    `toml:"extras"`
+			Group           map[string]struct {
+				Optional     bool                   `toml:"optional"`
+				Dependencies map[string]interface{} `toml:"dependencies"`
+			} `toml:"group"`
+			Source []struct {
+				Name     string `toml:"name"`
+				URL      string `toml:"url"`
+				Priority string `toml:"priority"`
+			} `toml:"source"`
+		} `toml:"poetry"`
+	} `toml:"tool"`
+
+	DependencyGroups map[string]interface{} `toml:"dependency-groups"`
+
+	metadata toml.MetaData
+}
+
+// ParsePyProject parses the pyproject.toml file at the given path.
+func ParsePyProject(path string) (PyProject, error) {
+	var pyProject PyProject
+	metadata, err := toml.DecodeFile(path, &pyProject)
+	if err != nil {
+		return PyProject{}, fmt.Errorf("failed to parse pyproject.toml:\nerror: %w", err)
+	}
+	pyProject.metadata = metadata
+
+	return pyProject, nil
+}
+
+// HasPoetryTable returns true when the file declares a [tool.poetry] table.
+func (p PyProject) HasPoetryTable() bool {
+	return p.metadata.IsDefined("tool", "poetry")
+}
+
+// UsesPoetryCore returns true when the project is built with the poetry-core
+// build backend.
+func (p PyProject) UsesPoetryCore() bool {
+	if p.BuildSystem.BuildBackend == PoetryCoreBuildBackend {
+		return true
+	}
+
+	for _, requirement := range p.BuildSystem.Requires {
+		name := strings.TrimSpace(requirement)
+		if index := strings.IndexAny(name, "<>=!~;[ "); index >= 0 {
+			name = name[:index]
+		}
+
+		if strings.EqualFold(name, "poetry-core") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PythonConstraint returns the python version constraint declared by the
+// project. The python entry in [tool.poetry.dependencies] takes precedence
+// over [project] requires-python, as Poetry uses it to narrow the latter.
+func (p PyProject) PythonConstraint() string {
+	if python, ok := p.Tool.Poetry.Dependencies["python"].(string); ok {
+		return strings.TrimSpace(python)
+	}
+
+	return strings.TrimSpace(p.Project.RequiresPython)
+}
+
+// semverConstraint translates a Poetry or PEP 440 version constraint into a
+// constraint understood by github.com/Masterminds/semver, as used by the
+// buildpacks that provide dependencies. It returns an empty string when the
+// constraint allows any version or cannot be translated.
+func semverConstraint(constraint string) string {
+	var alternatives []string
+	for _, alternative := range strings.Split(strings.ReplaceAll(constraint, "||", "|"), "|") {
+		var clauses []string
+		for _, clause := range strings.Split(alternative, ",") {
+			clause = strings.Join(strings.Fields(clause), "")
+			if clause == "" || clause == "*" {
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(clause, "~="):
+				translated, ok := compatibleRelease(strings.TrimPrefix(clause, "~="))
+				if !ok {
+					return ""
+				}
+				clause = translated
+			case strings.HasPrefix(clause, "==="):
+				clause = strings.TrimPrefix(clause, "===")
+			case strings.HasPrefix(clause, "=="):
+				clause = strings.TrimPrefix(clause, "==")
+			}
+
+			clauses = append(clauses, clause)
+		}
+
+		if len(clauses) == 0 {
+			return ""
+		}
+
+		alternatives = append(alternatives, strings.Join(clauses, ", "))
+	}
+
+	result := strings.Join(alternatives, " || ")
+	if _, err := semver.NewConstraint(result); err != nil {
+		return ""
+	}
+
+	return result
+}
+
+// compatibleRelease translates the PEP 440 compatible release clause "~=V"
+// into the equivalent pair of comparisons, for example "~=3.10" becomes
+// ">=3.10, <4" and "~=3.10.2" becomes ">=3.10.2, <3.11".
+func compatibleRelease(version string) (string, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return "", false
+	}
+
+	upper, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return "", false
+	}
+
+	bound := append(append([]string{}, parts[:len(parts)-2]...), strconv.Itoa(upper+1))
+
+	return fmt.Sprintf(">=%s, <%s", version, strings.Join(bound, ".")), true
+}
+
+// Extras returns the normalized names of the extras declared in
+// [tool.poetry.extras] and [project.optional-dependencies], sorted by name.
+func (p PyProject) Extras() []string {
+	names := map[string]bool{}
+	for name := range p.Tool.Poetry.Extras {
+		names[normalizeName(name)] = true
+	}
+
+	for name := range p.Project.OptionalDependencies {
+		names[normalizeName(name)] = true
+	}
+
+	var extras []string
+	for name := range names {
+		extras = append(extras, name)
+	}
+	sort.Strings(extras)
+
+	return extras
+}
+
+// normalizeName normalizes a package or extra name as described by
+// https://packaging.python.org/en/latest/specifications/name-normalization/.
+func normalizeName(name string) string {
+	return strings.ToLower(nameSeparatorPattern.ReplaceAllString(strings.TrimSpace(name), "-"))
+}
+
+// Groups returns the dependency groups declared in [tool.poetry.group] and
+// [dependency-groups], sorted by name with the implicit main group first.
+// The legacy [tool.poetry.dev-dependencies] table declares the dev group.
+func (p PyProject) Groups() []string {
+	var groups []string
+	for name := range p.Tool.Poetry.Group {
+		groups = appendUnique(groups, name)
+	}
+
+	if p.metadata.IsDefined("tool", "poetry", "dev-dependencies") {
+		groups = appendUnique(groups, "dev")
+	}
+
+	for name := range p.DependencyGroups {
+		groups = appendUnique(groups, name)
+	}
+	sort.Strings(groups)
+
+	return appendUnique([]string{"main"}, groups...)
+}
+
+// Name returns the name of the project from [project] or [tool.poetry].
+func (p PyProject) Name() string {
+	if p.Project.Name != "" {
+		return p.Project.Name
+	}
+
+	return p.Tool.Poetry.Name
+}
+
+// PackageMode returns false when the project sets package-mode = false, in
+// which case Poetry only manages its dependencies and never installs the
+// project itself.
+func (p PyProject) PackageMode() bool {
+	return p.Tool.Poetry.PackageMode == nil || *p.Tool.Poetry.PackageMode
+}
+
+// Scripts returns the names of the console scripts declared in
+// [tool.poetry.scripts] and [project.scripts], sorted by name.
+func (p PyProject) Scripts() []string {
+	var scripts []string
+	for name := range p.Tool.Poetry.Scripts {
+		scripts = appendUnique(scripts, name)
+	}
+
+	for name := range p.Project.Scripts {
+		scripts = appendUnique(scripts, name)
+	}
+	sort.Strings(scripts)
+
+	return scripts
+}
+
+// Sources returns the names of the package sources declared in
+// [[tool.poetry.source]].
+func (p PyProject) Sources() []string {
+	var sources []string
+	for _, source := range p.Tool.Poetry.Source {
+		sources = appendUnique(sources, source.Name)
+	}
+
+	return sources
+}
+
+// packageRequest is a dependency on a package, along with the extras of the
+// package it asks for.
+type packageRequest struct {
+	Name   string
+	Extras []string
+}
+
+// groupRequests returns the direct dependencies of each dependency group,
+// keyed by group name. The main group includes the dependencies that are only
+// installed for an extra.
+func (p PyProject) groupRequests() map[string][]packageRequest {
+	requests := map[string][]packageRequest{}
+
+	for name, dependency := range p.Tool.Poetry.Dependencies {
+		if name != "python" {
+			requests["main"] = append(requests["main"], poetryRequest(name, dependency))
+		}
+	}
+
+	for _, requirement := range p.Project.Dependencies {
+		requests["main"] = append(requests["main"], requirementRequest(requirement))
+	}
+
+	for _, requirements := range p.Project.OptionalDependencies {
+		for _, requirement := range requirements {
+			requests["main"] = append(requests["main"], requirementRequest(requirement))
+		}
+	}
+
+	for name, dependency := range p.Tool.Poetry.DevDependencies {
+		requests["dev"] = append(requests["dev"], poetryRequest(name, dependency))
+	}
+
+	for group, table := range p.Tool.Poetry.Group {
+		for name, dependency := range table.Dependencies {
+			requests[group] = append(requests[group], poetryRequest(name, dependency))
+		}
+	}
+
+	for group := range p.DependencyGroups {
+		requests[group] = append(requests[group], p.dependencyGroupRequests(group, nil)...)
+	}
+
+	return requests
+}
+
+// dependencyGroupRequests returns the requirements of a group in
+// [dependency-groups], including those of the groups it includes.
+func (p PyProject) dependencyGroupRequests(group string, included []string) []packageRequest {
+	entries, _ := p.DependencyGroups[group].([]interface{})
+
+	var requests []packageRequest
+	for _, entry := range entries {
+		switch entry := entry.(type) {
+		case string:
+			requests = append(requests, requirementRequest(entry))
+		case map[string]interface{}:
+			include, _ := entry["include-group"].(string)
+			if include != "" && include != group && !slices.Contains(included, include) {
+				requests = append(requests, p.dependencyGroupRequests(include, append(included, group))...)
+			}
+		}
+	}
+
+	return requests
+}
+
+// poetryRequest returns the request for a dependency declared in a Poetry
+// dependency table, given as a version constraint, a table or a list of
+// tables with different constraints.
+func poetryRequest(name string, dependency interface{}) packageRequest {
+	request := packageRequest{Name: normalizeName(name)}
+
+	var constraints []interface{}
+	switch dependency := dependency.(type) {
+	case map[string]interface{}:
+		constraints = []interface{}{dependency}
+	case []map[string]interface{}:
+		for _, constraint := range dependency {
+			constraints = append(constraints, constraint)
+		}
+	case []interface{}:
+		constraints = dependency
+	}
+
+	for _, constraint := range constraints {
+		table, _ := constraint.(map[string]interface{})
+		extras, _ := table["extras"].([]interface{})
+		for _, extra := range extras {
+			if extra, ok := extra.(string); ok {
+				request.Extras = appendUnique(request.Extras, normalizeName(extra))
+			}
+		}
+	}
+
+	return request
+}
+
+// requirementRequest returns the request for a PEP 508 requirement, such as
+// 'requests[socks]>=2.32 ; python_version >= "3.9"', or for a requirement of
+// the extras of a poetry.lock entry, such as 'PySocks (>=1.5.6,!=1.5.7)'.
+func requirementRequest(requirement string) packageRequest {
+	requirement = strings.TrimSpace(requirement)
+	end := strings.IndexFunc(requirement, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	})
+	if end < 0 {
+		end = len(requirement)
+	}
+
+	request := packageRequest{Name: normalizeName(requirement[:end])}
+
+	rest := strings.TrimSpace(requirement[end:])
+	if strings.HasPrefix(rest, "[") {
+		extras, _, _ := strings.Cut(strings.TrimPrefix(rest, "["), "]")
+		for _, extra := range strings.Split(extras, ",") {
+			if extra = strings.TrimSpace(extra); extra != "" {
+				request.Extras = appendUnique(request.Extras, normalizeName(extra))
+			}
+		}
+	}
+
+	return request
+}
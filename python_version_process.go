@@ -0,0 +1,44 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+)
+
+// PythonVersionProcess implements the PythonVersionLookupProcess interface.
+type PythonVersionProcess struct {
+	executable Executable
+}
+
+// NewPythonVersionProcess creates an instance of the PythonVersionProcess
+// given an Executable that invokes the python interpreter.
+func NewPythonVersionProcess(executable Executable) PythonVersionProcess {
+	return PythonVersionProcess{
+		executable: executable,
+	}
+}
+
+// Execute returns the version of the python interpreter available on the
+// PATH, as reported by `python --version`.
+func (p PythonVersionProcess) Execute() (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := p.executable.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up python version:\n%s\nerror: %w", buffer, err)
+	}
+
+	// The output looks like "Python 3.12.4"
+	fields := strings.Fields(buffer.String())
+	if len(fields) != 2 || fields[0] != "Python" {
+		return "", fmt.Errorf("failed to parse python version from output: '%s'", strings.TrimSpace(buffer.String()))
+	}
+
+	return fields[1], nil
+}
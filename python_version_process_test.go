@@ -0,0 +1,75 @@
+package poetryinstall_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+	"github.com/paketo-buildpacks/poetry-install/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testPythonVersionProcess(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		executable *fakes.Executable
+
+		pythonVersionProcess poetryinstall.PythonVersionProcess
+	)
+
+	it.Before(func() {
+		executable = &fakes.Executable{}
+		executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			_, err := fmt.Fprintln(execution.Stdout, "Python 3.12.4")
+			return err
+		}
+
+		pythonVersionProcess = poetryinstall.NewPythonVersionProcess(executable)
+	})
+
+	context("Execute", func() {
+		it("returns the version of the python interpreter", func() {
+			version, err := pythonVersionProcess.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("3.12.4"))
+
+			Expect(executable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+		})
+
+		context("failure cases", func() {
+			context("when the executable returns an error", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						_, err := fmt.Fprintln(execution.Stderr, "python: command not found")
+						Expect(err).NotTo(HaveOccurred())
+						return errors.New("exit status 127")
+					}
+				})
+
+				it("returns an error", func() {
+					_, err := pythonVersionProcess.Execute()
+					Expect(err).To(MatchError("failed to look up python version:\npython: command not found\n\nerror: exit status 127"))
+				})
+			})
+
+			context("when the output cannot be parsed", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						_, err := fmt.Fprintln(execution.Stdout, "something unexpected")
+						return err
+					}
+				})
+
+				it("returns an error", func() {
+					_, err := pythonVersionProcess.Execute()
+					Expect(err).To(MatchError("failed to parse python version from output: 'something unexpected'"))
+				})
+			})
+		})
+	})
+}
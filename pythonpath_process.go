@@ -1,22 +1,102 @@
 package poetryinstall
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
 )
 
-// PythonPathProcess implements the Executable interface.
+// sitePackagesScript prints the directories that the python of a virtual env
+// installs pure and platform-specific packages into.
+const sitePackagesScript = `import sysconfig
+print(sysconfig.get_path("purelib"))
+print(sysconfig.get_path("platlib"))`
+
+// PythonPathProcess implements the PythonPathLookupProcess interface.
 type PythonPathProcess struct {
+	executable Executable
 }
 
-// NewPythonPathProcess creates an instance of the PythonPathProcess.
-func NewPythonPathProcess() PythonPathProcess {
-	return PythonPathProcess{}
+// NewPythonPathProcess creates an instance of the PythonPathProcess given an
+// Executable that invokes the python interpreter.
+func NewPythonPathProcess(executable Executable) PythonPathProcess {
+	return PythonPathProcess{
+		executable: executable,
+	}
 }
 
-// Execute locates the Python path (AKA site-packages directory) within the poetry targetLayerPath.
+// Execute returns the site-packages directories of the virtual env in venvDir,
+// separated by os.PathListSeparator, as they are put on the PYTHONPATH. The
+// directories are those that the python of the virtual env reports as its
+// purelib and platlib paths, which are the same directory for most
+// interpreters. Directories outside of the virtual env are left out, as they
+// are reported when bin/python of the virtual env is missing and another
+// python on the PATH is run instead. When no directory remains, or python
+// cannot be run, the site-packages directory is located by the layout of the
+// virtual env instead.
 func (p PythonPathProcess) Execute(venvDir string) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := p.executable.Execute(pexec.Execution{
+		Args:   []string{"-c", sitePackagesScript},
+		Env:    append(os.Environ(), fmt.Sprintf("PATH=%s%c%s", filepath.Join(venvDir, "bin"), os.PathListSeparator, os.Getenv("PATH"))),
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return findSitePackages(venvDir)
+	}
+
+	realVenvDir, err := filepath.EvalSymlinks(venvDir)
+	if err != nil {
+		return findSitePackages(venvDir)
+	}
+
+	var (
+		dirs     []string
+		resolved []string
+	)
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		dir := strings.TrimSpace(line)
+		if dir == "" {
+			continue
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		// A platlib in lib64 is often a symlink to the purelib in lib.
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", err
+		}
+
+		if !isWithin(realDir, realVenvDir) {
+			continue
+		}
+
+		if !slices.Contains(resolved, realDir) {
+			resolved = append(resolved, realDir)
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+
+	if len(dirs) == 0 {
+		return findSitePackages(venvDir)
+	}
+
+	return strings.Join(dirs, string(os.PathListSeparator)), nil
+}
+
+// findSitePackages locates the site-packages directory by the layout of the
+// virtual env in venvDir.
+func findSitePackages(venvDir string) (string, error) {
 	// Poetry does not have a built in way to return the site-packages directory
 	// But we know the structure underneath the virtual env dir looks as follows:
 	// virtual-env-dir/pythonX.Y/lib/site-packages
@@ -1,12 +1,16 @@
 package poetryinstall_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/paketo-buildpacks/packit/v2/pexec"
 	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+	"github.com/paketo-buildpacks/poetry-install/fakes"
 	"github.com/sclevine/spec"
 
 	. "github.com/onsi/gomega"
@@ -16,7 +20,10 @@ func testPythonPathProcess(t *testing.T, context spec.G, it spec.S) {
 	var (
 		Expect = NewWithT(t).Expect
 
-		venvDir string
+		venvDir    string
+		sysconfig  []string
+		execution  pexec.Execution
+		executable *fakes.Executable
 
 		pythonPathProcess poetryinstall.PythonPathProcess
 	)
@@ -28,7 +35,19 @@ func testPythonPathProcess(t *testing.T, context spec.G, it spec.S) {
 
 		Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "python3.8", "site-packages"), os.ModePerm)).To(Succeed())
 
-		pythonPathProcess = poetryinstall.NewPythonPathProcess()
+		sysconfig = []string{
+			filepath.Join(venvDir, "lib", "python3.8", "site-packages"),
+			filepath.Join(venvDir, "lib", "python3.8", "site-packages"),
+		}
+
+		executable = &fakes.Executable{}
+		executable.ExecuteCall.Stub = func(e pexec.Execution) error {
+			execution = e
+			fmt.Fprintln(e.Stdout, strings.Join(sysconfig, "\n"))
+			return nil
+		}
+
+		pythonPathProcess = poetryinstall.NewPythonPathProcess(executable)
 	})
 
 	it.After(func() {
@@ -36,106 +55,212 @@ func testPythonPathProcess(t *testing.T, context spec.G, it spec.S) {
 	})
 
 	context("Execute", func() {
-		it("runs installation", func() {
+		it("asks the python of the virtual env for its site-packages directory", func() {
 			pythonPath, err := pythonPathProcess.Execute(venvDir)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
+
+			Expect(execution.Args).To(HaveLen(2))
+			Expect(execution.Args[0]).To(Equal("-c"))
+			Expect(execution.Args[1]).To(ContainSubstring(`sysconfig.get_path("purelib")`))
+			Expect(execution.Args[1]).To(ContainSubstring(`sysconfig.get_path("platlib")`))
+			Expect(execution.Env).To(ContainElement(HavePrefix(fmt.Sprintf("PATH=%s%c", filepath.Join(venvDir, "bin"), os.PathListSeparator))))
 		})
 
-		context("failure cases", func() {
-			context("when the venvDir/lib directory cannot be read", func() {
-				it.Before(func() {
-					Expect(os.Chmod(filepath.Join(venvDir, "lib"), 0000)).To(Succeed())
-				})
+		context("when purelib and platlib are different directories", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(venvDir, "lib64", "python3.8", "site-packages"), os.ModePerm)).To(Succeed())
+				sysconfig[1] = filepath.Join(venvDir, "lib64", "python3.8", "site-packages")
+			})
 
-				it.After(func() {
-					Expect(os.Chmod(filepath.Join(venvDir, "lib"), os.ModePerm)).To(Succeed())
-				})
+			it("returns both directories", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to read directory: '%s'", filepath.Join(venvDir, "lib")))))
-					Expect(err).To(MatchError(ContainSubstring("permission denied")))
-				})
+				Expect(pythonPath).To(Equal(strings.Join([]string{
+					filepath.Join(venvDir, "lib", "python3.8", "site-packages"),
+					filepath.Join(venvDir, "lib64", "python3.8", "site-packages"),
+				}, string(os.PathListSeparator))))
 			})
+		})
 
-			context("when there are too many entries in the venv/lib directory", func() {
-				it.Before(func() {
-					Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "additional"), os.ModePerm)).To(Succeed())
-				})
+		context("when platlib is a symlink to purelib", func() {
+			it.Before(func() {
+				Expect(os.Symlink("lib", filepath.Join(venvDir, "lib64"))).To(Succeed())
+				sysconfig[1] = filepath.Join(venvDir, "lib64", "python3.8", "site-packages")
+			})
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(fmt.Sprintf("expected one directory and zero files in directory: '%s' - found multiple", filepath.Join(venvDir, "lib"))))
-				})
+			it("returns the directory once", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
+			})
+		})
+
+		context("when a reported directory does not exist", func() {
+			it.Before(func() {
+				sysconfig[1] = filepath.Join(venvDir, "lib64", "python3.8", "site-packages")
 			})
 
-			context("If the entry at venv/lib/python* is not a directory", func() {
-				it.Before(func() {
-					Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8"))).To(Succeed())
+			it("leaves it out", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
 
-					_, err := os.Create(filepath.Join(venvDir, "lib", "python3.8"))
-					Expect(err).NotTo(HaveOccurred())
-				})
+				Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
+			})
+		})
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("expected a directory at: '%s'", filepath.Join(venvDir, "lib", "python3.8")))))
-				})
+		context("when a reported directory is outside of the virtual env", func() {
+			var otherPythonDir string
+
+			it.Before(func() {
+				var err error
+				otherPythonDir, err = os.MkdirTemp("", "cpython")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.MkdirAll(filepath.Join(otherPythonDir, "lib", "python3.8", "site-packages"), os.ModePerm)).To(Succeed())
+				Expect(os.Symlink(filepath.Join(otherPythonDir, "lib"), filepath.Join(venvDir, "lib64"))).To(Succeed())
+
+				sysconfig = []string{
+					filepath.Join(otherPythonDir, "lib", "python3.8", "site-packages"),
+					filepath.Join(venvDir, "lib64", "python3.8", "site-packages"),
+				}
 			})
 
-			context("when the venvDir/lib/python* directory cannot be read", func() {
-				it.Before(func() {
-					Expect(os.Chmod(filepath.Join(venvDir, "lib", "python3.8"), 0000)).To(Succeed())
-				})
+			it.After(func() {
+				Expect(os.RemoveAll(otherPythonDir)).To(Succeed())
+			})
 
-				it.After(func() {
-					Expect(os.Chmod(filepath.Join(venvDir, "lib", "python3.8"), os.ModePerm)).To(Succeed())
-				})
+			it("leaves it out and finds the site-packages directory by the layout of the virtual env", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to read directory: '%s'", filepath.Join(venvDir, "lib", "python3.8")))))
-					Expect(err).To(MatchError(ContainSubstring("permission denied")))
-				})
+				Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
 			})
+		})
+
+		context("when the python of the virtual env reports no existing directory", func() {
+			it.Before(func() {
+				sysconfig = []string{filepath.Join(venvDir, "some-other-dir")}
+			})
+
+			it("finds the site-packages directory by the layout of the virtual env", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
+			})
+		})
+
+		context("when the python of the virtual env cannot be run", func() {
+			it.Before(func() {
+				executable.ExecuteCall.Stub = nil
+				executable.ExecuteCall.Returns.Error = errors.New("exit status 127")
+			})
+
+			it("finds the site-packages directory by the layout of the virtual env", func() {
+				pythonPath, err := pythonPathProcess.Execute(venvDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(pythonPath).To(Equal(filepath.Join(venvDir, "lib", "python3.8", "site-packages")))
+			})
+
+			context("failure cases", func() {
+				context("when the venvDir/lib directory cannot be read", func() {
+					it.Before(func() {
+						Expect(os.Chmod(filepath.Join(venvDir, "lib"), 0000)).To(Succeed())
+					})
 
-			context("when there are too many entries in the venv/lib/python* directory", func() {
-				it.Before(func() {
-					Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "python3.8", "additional"), os.ModePerm)).To(Succeed())
+					it.After(func() {
+						Expect(os.Chmod(filepath.Join(venvDir, "lib"), os.ModePerm)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to read directory: '%s'", filepath.Join(venvDir, "lib")))))
+						Expect(err).To(MatchError(ContainSubstring("permission denied")))
+					})
 				})
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(fmt.Sprintf("expected one directory and zero files in directory: '%s' - found multiple", filepath.Join(venvDir, "lib", "python3.8"))))
+				context("when there are too many entries in the venv/lib directory", func() {
+					it.Before(func() {
+						Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "additional"), os.ModePerm)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(fmt.Sprintf("expected one directory and zero files in directory: '%s' - found multiple", filepath.Join(venvDir, "lib"))))
+					})
 				})
-			})
 
-			context("If the only entry under venv/lib/python*/ is not called site-packages", func() {
-				it.Before(func() {
-					Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))).To(Succeed())
+				context("If the entry at venv/lib/python* is not a directory", func() {
+					it.Before(func() {
+						Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8"))).To(Succeed())
+
+						_, err := os.Create(filepath.Join(venvDir, "lib", "python3.8"))
+						Expect(err).NotTo(HaveOccurred())
+					})
 
-					Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "python3.8", "other-directory"), os.ModePerm)).To(Succeed())
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("expected a directory at: '%s'", filepath.Join(venvDir, "lib", "python3.8")))))
+					})
 				})
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf(`expected "site-packages" directory at: '%s'`, filepath.Join(venvDir, "lib", "python3.8", "site-packages")))))
+				context("when the venvDir/lib/python* directory cannot be read", func() {
+					it.Before(func() {
+						Expect(os.Chmod(filepath.Join(venvDir, "lib", "python3.8"), 0000)).To(Succeed())
+					})
+
+					it.After(func() {
+						Expect(os.Chmod(filepath.Join(venvDir, "lib", "python3.8"), os.ModePerm)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to read directory: '%s'", filepath.Join(venvDir, "lib", "python3.8")))))
+						Expect(err).To(MatchError(ContainSubstring("permission denied")))
+					})
 				})
-			})
 
-			context("If the entry at venv/lib/python*/site-packages is not a directory", func() {
-				it.Before(func() {
-					Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))).To(Succeed())
+				context("when there are too many entries in the venv/lib/python* directory", func() {
+					it.Before(func() {
+						Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "python3.8", "additional"), os.ModePerm)).To(Succeed())
+					})
 
-					_, err := os.Create(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))
-					Expect(err).NotTo(HaveOccurred())
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(fmt.Sprintf("expected one directory and zero files in directory: '%s' - found multiple", filepath.Join(venvDir, "lib", "python3.8"))))
+					})
 				})
 
-				it("returns an error", func() {
-					_, err := pythonPathProcess.Execute(venvDir)
-					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("expected a directory at: '%s'", filepath.Join(venvDir, "lib", "python3.8", "site-packages")))))
+				context("If the only entry under venv/lib/python*/ is not called site-packages", func() {
+					it.Before(func() {
+						Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))).To(Succeed())
+
+						Expect(os.MkdirAll(filepath.Join(venvDir, "lib", "python3.8", "other-directory"), os.ModePerm)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf(`expected "site-packages" directory at: '%s'`, filepath.Join(venvDir, "lib", "python3.8", "site-packages")))))
+					})
+				})
+
+				context("If the entry at venv/lib/python*/site-packages is not a directory", func() {
+					it.Before(func() {
+						Expect(os.RemoveAll(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))).To(Succeed())
+
+						_, err := os.Create(filepath.Join(venvDir, "lib", "python3.8", "site-packages"))
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					it("returns an error", func() {
+						_, err := pythonPathProcess.Execute(venvDir)
+						Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("expected a directory at: '%s'", filepath.Join(venvDir, "lib", "python3.8", "site-packages")))))
+					})
 				})
 			})
 		})
@@ -7,17 +7,11 @@ import (
 	"github.com/paketo-buildpacks/packit/v2/chronos"
 	"github.com/paketo-buildpacks/packit/v2/draft"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
-	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
 	poetryinstall "github.com/paketo-buildpacks/poetry-install"
 )
 
-type Generator struct{}
-
-func (f Generator) Generate(dir string) (sbom.SBOM, error) {
-	return sbom.Generate(dir)
-}
-
 func main() {
 	logger := scribe.NewEmitter(os.Stdout).WithLevel(os.Getenv("BP_LOG_LEVEL"))
 
@@ -25,9 +19,13 @@ func main() {
 		poetryinstall.Detect(),
 		poetryinstall.Build(
 			draft.NewPlanner(),
-			poetryinstall.NewPoetryInstallProcess(pexec.NewExecutable("poetry"), logger),
-			poetryinstall.NewPythonPathProcess(),
-			Generator{},
+			poetryinstall.NewPoetryInstallProcess(pexec.NewExecutable("poetry"), poetryinstall.NewPythonPathProcess(pexec.NewExecutable("python")), logger),
+			poetryinstall.NewPoetryVenvNormalizer(),
+			poetryinstall.NewPythonPathProcess(pexec.NewExecutable("python")),
+			poetryinstall.NewPoetryDebugSymbolStripper(pexec.NewExecutable("strip")),
+			poetryinstall.NewPythonVersionProcess(pexec.NewExecutable("python")),
+			poetryinstall.NewPoetrySBOMGenerator(),
+			servicebindings.NewResolver(),
 			chronos.DefaultClock,
 			logger,
 		),
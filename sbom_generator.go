@@ -0,0 +1,318 @@
+package poetryinstall
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anchore/packageurl-go"
+	"github.com/anchore/syft/syft/file"
+	"github.com/anchore/syft/syft/pkg"
+	syftsbom "github.com/anchore/syft/syft/sbom"
+	"github.com/anchore/syft/syft/source"
+	"github.com/paketo-buildpacks/packit/v2/fs"
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+)
+
+// PoetrySBOMGenerator implements the SBOMGenerator interface.
+type PoetrySBOMGenerator struct{}
+
+// NewPoetrySBOMGenerator creates an instance of the PoetrySBOMGenerator.
+func NewPoetrySBOMGenerator() PoetrySBOMGenerator {
+	return PoetrySBOMGenerator{}
+}
+
+// Generate returns an SBOM of the packages installed into the site-packages
+// directory of a virtual env, or into each of the directories when
+// sitePackagesDir is a list separated by os.PathListSeparator. Each package
+// is described by its .dist-info metadata and, when it is locked, by its
+// poetry.lock entry in workingDir for its name and version, which provides
+// the hashes of its distribution files, the source it was installed from and
+// the dependency groups it belongs to. The groups are recorded as a qualifier
+// of the package URL, as that is kept by all the SBOM formats.
+func (g PoetrySBOMGenerator) Generate(workingDir, sitePackagesDir string) (sbom.SBOM, error) {
+	locked := map[lockedPackageKey]PoetryLockPackage{}
+
+	lockPath := filepath.Join(workingDir, "poetry.lock")
+	exists, err := fs.Exists(lockPath)
+	if err != nil {
+		return sbom.SBOM{}, err
+	}
+
+	if exists {
+		lock, err := ParsePoetryLock(lockPath)
+		if err != nil {
+			return sbom.SBOM{}, err
+		}
+
+		for _, lockPackage := range lock.Packages {
+			locked[lockedPackageKey{normalizeName(lockPackage.Name), lockPackage.Version}] = lockPackage
+		}
+	}
+
+	var distInfoDirs []string
+	for _, dir := range filepath.SplitList(sitePackagesDir) {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.dist-info"))
+		if err != nil {
+			return sbom.SBOM{}, err
+		}
+		sort.Strings(matches)
+		distInfoDirs = append(distInfoDirs, matches...)
+	}
+
+	var packages []pkg.Package
+	for _, distInfoDir := range distInfoDirs {
+		metadata, err := readDistInfo(distInfoDir)
+		if err != nil {
+			return sbom.SBOM{}, err
+		}
+		metadata.SitePackagesRootPath = filepath.Dir(distInfoDir)
+
+		location := file.NewLocation(filepath.Join(distInfoDir, "METADATA"))
+
+		var qualifiers packageurl.Qualifiers
+		lockPackage, ok := locked[lockedPackageKey{normalizeName(metadata.Name), metadata.Version}]
+		if ok {
+			location = location.WithAnnotation("poetry.lock", lockPath)
+			if groups := lockPackage.PackageGroups(); len(groups) > 0 {
+				location = location.WithAnnotation("groups", strings.Join(groups, ","))
+			}
+
+			qualifiers = lockQualifiers(lockPackage)
+			if metadata.DirectURLOrigin == nil && lockPackage.Source.Type == "git" {
+				metadata.DirectURLOrigin = &pkg.PythonDirectURLOriginInfo{
+					URL:      lockPackage.Source.URL,
+					CommitID: lockPackage.Source.ResolvedReference,
+					VCS:      "git",
+				}
+			}
+		}
+
+		packageURL := packageurl.NewPackageURL(packageurl.TypePyPi, "", normalizeName(metadata.Name), metadata.Version, qualifiers, "")
+
+		syftPackage := pkg.Package{
+			Name:      metadata.Name,
+			Version:   metadata.Version,
+			FoundBy:   "poetry-install",
+			Locations: file.NewLocationSet(location),
+			Licenses:  pkg.NewLicenseSet(distInfoLicenses(distInfoDir, metadata)...),
+			Language:  pkg.Python,
+			Type:      pkg.PythonPkg,
+			PURL:      packageURL.ToString(),
+			Metadata:  metadata.PythonPackage,
+		}
+		syftPackage.SetID()
+
+		packages = append(packages, syftPackage)
+	}
+
+	return sbom.NewSBOM(syftsbom.SBOM{
+		Artifacts: syftsbom.Artifacts{
+			Packages: pkg.NewCollection(packages...),
+		},
+		Source: source.Description{
+			Metadata: source.DirectoryMetadata{
+				Path: sitePackagesDir,
+			},
+		},
+	}), nil
+}
+
+// lockedPackageKey identifies a poetry.lock entry, as a package can be locked
+// at several versions for different environment markers.
+type lockedPackageKey struct {
+	Name    string
+	Version string
+}
+
+// lockQualifiers returns the package URL qualifiers that record the hashes of
+// the distribution files, the source and the dependency groups of a locked
+// package.
+func lockQualifiers(lockPackage PoetryLockPackage) packageurl.Qualifiers {
+	var qualifiers packageurl.Qualifiers
+
+	var checksums []string
+	for _, lockFile := range lockPackage.Files {
+		if lockFile.Hash != "" {
+			checksums = append(checksums, lockFile.Hash)
+		}
+	}
+
+	if len(checksums) > 0 {
+		qualifiers = append(qualifiers, packageurl.Qualifier{Key: "checksum", Value: strings.Join(checksums, ",")})
+	}
+
+	switch lockPackage.Source.Type {
+	case "legacy":
+		qualifiers = append(qualifiers, packageurl.Qualifier{Key: "repository_url", Value: lockPackage.Source.URL})
+	case "git":
+		vcsURL := "git+" + lockPackage.Source.URL
+		if lockPackage.Source.ResolvedReference != "" {
+			vcsURL = fmt.Sprintf("%s@%s", vcsURL, lockPackage.Source.ResolvedReference)
+		}
+		qualifiers = append(qualifiers, packageurl.Qualifier{Key: "vcs_url", Value: vcsURL})
+	case "url":
+		qualifiers = append(qualifiers, packageurl.Qualifier{Key: "download_url", Value: lockPackage.Source.URL})
+	}
+
+	if groups := lockPackage.PackageGroups(); len(groups) > 0 {
+		qualifiers = append(qualifiers, packageurl.Qualifier{Key: "groups", Value: strings.Join(groups, ",")})
+	}
+
+	return qualifiers
+}
+
+// distInfo holds the metadata of an installed package together with the
+// license fields of its METADATA file.
+type distInfo struct {
+	pkg.PythonPackage
+
+	LicenseExpression string
+	License           string
+	Classifiers       []string
+}
+
+// readDistInfo reads the METADATA, RECORD and direct_url.json files of a
+// .dist-info directory.
+func readDistInfo(distInfoDir string) (distInfo, error) {
+	metadataFile, err := os.Open(filepath.Join(distInfoDir, "METADATA"))
+	if err != nil {
+		return distInfo{}, fmt.Errorf("failed to open package metadata:\nerror: %w", err)
+	}
+	defer metadataFile.Close()
+
+	header, err := textproto.NewReader(bufio.NewReader(metadataFile)).ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return distInfo{}, fmt.Errorf("failed to parse package metadata '%s':\nerror: %w", metadataFile.Name(), err)
+	}
+
+	info := distInfo{
+		PythonPackage: pkg.PythonPackage{
+			Name:           header.Get("Name"),
+			Version:        header.Get("Version"),
+			Author:         header.Get("Author"),
+			AuthorEmail:    header.Get("Author-Email"),
+			Platform:       header.Get("Platform"),
+			RequiresPython: header.Get("Requires-Python"),
+			RequiresDist:   header.Values("Requires-Dist"),
+			ProvidesExtra:  header.Values("Provides-Extra"),
+		},
+		LicenseExpression: header.Get("License-Expression"),
+		License:           header.Get("License"),
+		Classifiers:       header.Values("Classifier"),
+	}
+
+	if info.Name == "" {
+		return distInfo{}, fmt.Errorf("failed to parse package metadata '%s': no package name found", metadataFile.Name())
+	}
+
+	info.Files, err = readRecord(filepath.Join(distInfoDir, "RECORD"))
+	if err != nil {
+		return distInfo{}, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(distInfoDir, "direct_url.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return distInfo{}, err
+	}
+
+	if err == nil {
+		var directURL struct {
+			URL     string `json:"url"`
+			VCSInfo struct {
+				VCS      string `json:"vcs"`
+				CommitID string `json:"commit_id"`
+			} `json:"vcs_info"`
+		}
+		if err := json.Unmarshal(content, &directURL); err != nil {
+			return distInfo{}, fmt.Errorf("failed to parse direct_url.json of '%s':\nerror: %w", info.Name, err)
+		}
+
+		if directURL.URL != "" && !strings.HasPrefix(directURL.URL, "file:") {
+			info.DirectURLOrigin = &pkg.PythonDirectURLOriginInfo{
+				URL:      directURL.URL,
+				CommitID: directURL.VCSInfo.CommitID,
+				VCS:      directURL.VCSInfo.VCS,
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// readRecord reads the files listed in a RECORD file, if there is one.
+func readRecord(path string) ([]pkg.PythonFileRecord, error) {
+	recordFile, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer recordFile.Close()
+
+	reader := csv.NewReader(recordFile)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s':\nerror: %w", path, err)
+	}
+
+	var records []pkg.PythonFileRecord
+	for _, row := range rows {
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+
+		record := pkg.PythonFileRecord{Path: row[0]}
+		if len(row) > 1 {
+			if algorithm, value, ok := strings.Cut(row[1], "="); ok {
+				record.Digest = &pkg.PythonFileDigest{Algorithm: algorithm, Value: value}
+			}
+		}
+
+		if len(row) > 2 {
+			record.Size = row[2]
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// distInfoLicenses returns the licenses declared by an installed package. The
+// SPDX License-Expression field takes precedence over the free-form License
+// field, which is in turn preferred over the license trove classifiers.
+func distInfoLicenses(distInfoDir string, info distInfo) []pkg.License {
+	location := file.NewLocation(filepath.Join(distInfoDir, "METADATA"))
+
+	var values []string
+	switch {
+	case info.LicenseExpression != "":
+		values = []string{info.LicenseExpression}
+	case info.License != "" && !strings.Contains(info.License, "\n"):
+		values = []string{info.License}
+	default:
+		for _, classifier := range info.Classifiers {
+			if !strings.HasPrefix(classifier, "License ::") {
+				continue
+			}
+
+			parts := strings.Split(classifier, "::")
+			values = append(values, strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+
+	return pkg.NewLicensesFromLocationWithContext(context.Background(), location, values...)
+}
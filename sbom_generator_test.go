@@ -0,0 +1,349 @@
+package poetryinstall_test
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSBOMGenerator(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		workingDir      string
+		sitePackagesDir string
+
+		generator poetryinstall.PoetrySBOMGenerator
+	)
+
+	writeDistInfo := func(name string, files map[string]string) {
+		distInfoDir := filepath.Join(sitePackagesDir, name)
+		Expect(os.MkdirAll(distInfoDir, os.ModePerm)).To(Succeed())
+
+		for file, content := range files {
+			Expect(os.WriteFile(filepath.Join(distInfoDir, file), []byte(content), 0600)).To(Succeed())
+		}
+	}
+
+	// formatted returns the artifacts of the SBOM in the syft format and the
+	// components of the SBOM in the CycloneDX format.
+	formatted := func(content sbom.SBOM) ([]map[string]interface{}, []map[string]interface{}) {
+		formatter, err := content.InFormats(sbom.SyftFormat, sbom.CycloneDXFormat)
+		Expect(err).NotTo(HaveOccurred())
+
+		formats := formatter.Formats()
+		Expect(formats).To(HaveLen(2))
+
+		var syft struct {
+			Artifacts []map[string]interface{} `json:"artifacts"`
+		}
+		output, err := io.ReadAll(formats[0].Content)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(output, &syft)).To(Succeed())
+
+		var cycloneDX struct {
+			Components []map[string]interface{} `json:"components"`
+		}
+		output, err = io.ReadAll(formats[1].Content)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(output, &cycloneDX)).To(Succeed())
+
+		return syft.Artifacts, cycloneDX.Components
+	}
+
+	it.Before(func() {
+		var err error
+		workingDir, err = os.MkdirTemp("", "workingdir")
+		Expect(err).NotTo(HaveOccurred())
+
+		sitePackagesDir = filepath.Join(workingDir, "venv", "lib", "python3.12", "site-packages")
+		Expect(os.MkdirAll(sitePackagesDir, os.ModePerm)).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte(`# This file is automatically @generated by Poetry 2.1.1 and should not be changed by hand.
+
+[[package]]
+name = "requests"
+version = "2.32.3"
+description = "Python HTTP for Humans."
+optional = false
+python-versions = ">=3.8"
+groups = ["main", "dev"]
+files = [
+    {file = "requests-2.32.3-py3-none-any.whl", hash = "sha256:70761cfe03c773ceb22aa2f671b4757976145175cdfca038c02654d061d6dcc6"},
+    {file = "requests-2.32.3.tar.gz", hash = "sha256:55365417734eb18255590a9ff9eb97e9e1da868d4ccd6402399eaf68af20a760"},
+]
+
+[[package]]
+name = "some-lib"
+version = "0.1.0"
+description = ""
+optional = false
+python-versions = "*"
+groups = ["main"]
+files = []
+
+[package.source]
+type = "git"
+url = "https://github.com/some-org/some-lib.git"
+reference = "main"
+resolved_reference = "0123456789abcdef"
+
+[[package]]
+name = "sphinx"
+version = "7.4.7"
+description = "Python documentation generator"
+optional = false
+python-versions = ">=3.9"
+groups = ["docs"]
+files = []
+
+[metadata]
+lock-version = "2.1"
+python-versions = "^3.12"
+content-hash = "some-hash"
+`), 0600)).To(Succeed())
+
+		writeDistInfo("requests-2.32.3.dist-info", map[string]string{
+			"METADATA": `Metadata-Version: 2.1
+Name: requests
+Version: 2.32.3
+Author: Kenneth Reitz
+License: Apache-2.0
+Requires-Python: >=3.8
+
+Requests is an elegant and simple HTTP library for Python.
+`,
+			"RECORD": `requests/__init__.py,sha256=some-digest,4947
+requests-2.32.3.dist-info/RECORD,,
+`,
+		})
+
+		writeDistInfo("some_lib-0.1.0.dist-info", map[string]string{
+			"METADATA": `Metadata-Version: 2.1
+Name: some_lib
+Version: 0.1.0
+Classifier: Programming Language :: Python :: 3
+Classifier: License :: OSI Approved :: MIT License
+`,
+			"direct_url.json": `{"url": "https://github.com/some-org/some-lib.git", "vcs_info": {"vcs": "git", "commit_id": "0123456789abcdef"}}`,
+		})
+
+		writeDistInfo("pip-24.0.dist-info", map[string]string{
+			"METADATA": `Metadata-Version: 2.1
+Name: pip
+Version: 24.0
+License-Expression: MIT
+`,
+		})
+
+		generator = poetryinstall.NewPoetrySBOMGenerator()
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(workingDir)).To(Succeed())
+	})
+
+	context("Generate", func() {
+		it("describes the packages installed into the virtual env", func() {
+			content, err := generator.Generate(workingDir, sitePackagesDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			artifacts, components := formatted(content)
+			Expect(artifacts).To(HaveLen(3))
+			Expect(components).To(HaveLen(3))
+
+			artifactsByName := map[string]map[string]interface{}{}
+			for _, artifact := range artifacts {
+				artifactsByName[artifact["name"].(string)] = artifact
+			}
+			Expect(artifactsByName).To(HaveKey("requests"))
+			Expect(artifactsByName).To(HaveKey("some_lib"))
+			Expect(artifactsByName).To(HaveKey("pip"))
+			Expect(artifactsByName).NotTo(HaveKey("sphinx"))
+
+			requests := artifactsByName["requests"]
+			Expect(requests["version"]).To(Equal("2.32.3"))
+			Expect(requests["purl"]).To(Equal("pkg:pypi/requests@2.32.3?checksum=sha256%3A70761cfe03c773ceb22aa2f671b4757976145175cdfca038c02654d061d6dcc6%2Csha256%3A55365417734eb18255590a9ff9eb97e9e1da868d4ccd6402399eaf68af20a760&groups=main%2Cdev"))
+			Expect(requests["licenses"]).To(ContainElement(HaveKeyWithValue("value", "Apache-2.0")))
+			Expect(requests["locations"]).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("path", filepath.Join(sitePackagesDir, "requests-2.32.3.dist-info", "METADATA")),
+				HaveKeyWithValue("annotations", SatisfyAll(
+					HaveKeyWithValue("groups", "main,dev"),
+					HaveKeyWithValue("poetry.lock", filepath.Join(workingDir, "poetry.lock")),
+				)),
+			)))
+			Expect(requests["metadata"]).To(SatisfyAll(
+				HaveKeyWithValue("author", "Kenneth Reitz"),
+				HaveKeyWithValue("requiresPython", ">=3.8"),
+				HaveKeyWithValue("files", ContainElement(SatisfyAll(
+					HaveKeyWithValue("path", "requests/__init__.py"),
+					HaveKeyWithValue("digest", map[string]interface{}{"algorithm": "sha256", "value": "some-digest"}),
+				))),
+			))
+
+			someLib := artifactsByName["some_lib"]
+			Expect(someLib["purl"]).To(Equal("pkg:pypi/some-lib@0.1.0?groups=main&vcs_url=git%2Bhttps%3A%2F%2Fgithub.com%2Fsome-org%2Fsome-lib.git%400123456789abcdef"))
+			Expect(someLib["licenses"]).To(ContainElement(HaveKeyWithValue("value", "MIT License")))
+			Expect(someLib["metadata"]).To(HaveKeyWithValue("directUrlOrigin", map[string]interface{}{
+				"url":      "https://github.com/some-org/some-lib.git",
+				"commitId": "0123456789abcdef",
+				"vcs":      "git",
+			}))
+
+			pip := artifactsByName["pip"]
+			Expect(pip["purl"]).To(Equal("pkg:pypi/pip@24.0"))
+			Expect(pip["licenses"]).To(ContainElement(HaveKeyWithValue("spdxExpression", "MIT")))
+			Expect(pip["locations"]).To(ConsistOf(Not(HaveKey("annotations"))))
+
+			Expect(components).To(ContainElement(SatisfyAll(
+				HaveKeyWithValue("name", "some_lib"),
+				HaveKeyWithValue("externalReferences", ContainElement(SatisfyAll(
+					HaveKeyWithValue("url", "https://github.com/some-org/some-lib.git"),
+					HaveKeyWithValue("type", "vcs"),
+				))),
+			)))
+		})
+
+		it("records the groups of the locked packages in the CycloneDX and SPDX formats", func() {
+			content, err := generator.Generate(workingDir, sitePackagesDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			formatter, err := content.InFormats(sbom.CycloneDXFormat, sbom.SPDXFormat)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, format := range formatter.Formats() {
+				output, err := io.ReadAll(format.Content)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output)).To(ContainSubstring("pkg:pypi/requests@2.32.3?checksum="))
+				Expect(string(output)).To(ContainSubstring("groups=main%2Cdev"), format.Extension)
+			}
+		})
+
+		context("when a package is locked at several versions", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte(`
+[[package]]
+name = "numpy"
+version = "1.24.4"
+groups = ["main"]
+markers = "python_version < \"3.9\""
+files = [
+    {file = "numpy-1.24.4.tar.gz", hash = "sha256:80f5e3a4e498641401868df4208b74581206afbee7cf7b8329daae82676d9463"},
+]
+
+[[package]]
+name = "numpy"
+version = "1.26.4"
+groups = ["main"]
+markers = "python_version >= \"3.9\""
+files = [
+    {file = "numpy-1.26.4.tar.gz", hash = "sha256:2a02aba9ed12e4ac4eb3ea9421c420301a0c6460d9830d74a9df87efa4912010"},
+]
+`), 0600)).To(Succeed())
+
+				writeDistInfo("numpy-1.26.4.dist-info", map[string]string{
+					"METADATA": "Metadata-Version: 2.1\nName: numpy\nVersion: 1.26.4\n",
+				})
+			})
+
+			it("describes the package with the entry of the installed version", func() {
+				content, err := generator.Generate(workingDir, sitePackagesDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				artifacts, _ := formatted(content)
+				Expect(artifacts).To(ContainElement(SatisfyAll(
+					HaveKeyWithValue("name", "numpy"),
+					HaveKeyWithValue("purl", "pkg:pypi/numpy@1.26.4?checksum=sha256%3A2a02aba9ed12e4ac4eb3ea9421c420301a0c6460d9830d74a9df87efa4912010&groups=main"),
+				)))
+			})
+		})
+
+		context("when the packages are installed into more than one directory", func() {
+			var platlibDir string
+
+			it.Before(func() {
+				platlibDir = filepath.Join(workingDir, "venv", "lib64", "python3.12", "site-packages")
+				Expect(os.MkdirAll(filepath.Join(platlibDir, "numpy-2.0.0.dist-info"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(platlibDir, "numpy-2.0.0.dist-info", "METADATA"), []byte("Metadata-Version: 2.1\nName: numpy\nVersion: 2.0.0\n"), 0600)).To(Succeed())
+			})
+
+			it("describes the packages in each directory", func() {
+				content, err := generator.Generate(workingDir, strings.Join([]string{sitePackagesDir, platlibDir}, string(os.PathListSeparator)))
+				Expect(err).NotTo(HaveOccurred())
+
+				artifacts, _ := formatted(content)
+				Expect(artifacts).To(HaveLen(4))
+				Expect(artifacts).To(ContainElement(SatisfyAll(
+					HaveKeyWithValue("name", "numpy"),
+					HaveKeyWithValue("locations", ConsistOf(HaveKeyWithValue("path", filepath.Join(platlibDir, "numpy-2.0.0.dist-info", "METADATA")))),
+				)))
+				Expect(artifacts).To(ContainElement(HaveKeyWithValue("name", "requests")))
+			})
+		})
+
+		context("when there is no poetry.lock", func() {
+			it.Before(func() {
+				Expect(os.Remove(filepath.Join(workingDir, "poetry.lock"))).To(Succeed())
+			})
+
+			it("describes the installed packages without lock information", func() {
+				content, err := generator.Generate(workingDir, sitePackagesDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				artifacts, _ := formatted(content)
+				Expect(artifacts).To(ContainElement(SatisfyAll(
+					HaveKeyWithValue("name", "requests"),
+					HaveKeyWithValue("purl", "pkg:pypi/requests@2.32.3"),
+				)))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the poetry.lock cannot be parsed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "poetry.lock"), []byte("%%%"), 0600)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := generator.Generate(workingDir, sitePackagesDir)
+					Expect(err).To(MatchError(ContainSubstring("failed to parse poetry.lock")))
+				})
+			})
+
+			context("when the package metadata has no name", func() {
+				it.Before(func() {
+					writeDistInfo("broken-1.0.dist-info", map[string]string{
+						"METADATA": "Metadata-Version: 2.1\nVersion: 1.0\n",
+					})
+				})
+
+				it("returns an error", func() {
+					_, err := generator.Generate(workingDir, sitePackagesDir)
+					Expect(err).To(MatchError(ContainSubstring("no package name found")))
+				})
+			})
+
+			context("when the RECORD file cannot be parsed", func() {
+				it.Before(func() {
+					writeDistInfo("requests-2.32.3.dist-info", map[string]string{
+						"RECORD": "\"unterminated,sha256=some-digest,1\n",
+					})
+				})
+
+				it("returns an error", func() {
+					_, err := generator.Generate(workingDir, sitePackagesDir)
+					Expect(err).To(MatchError(ContainSubstring("failed to parse")))
+				})
+			})
+		})
+	})
+}
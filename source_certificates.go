@@ -0,0 +1,219 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
+
+// CertificateBindingTypes are the service binding types that provide TLS
+// certificates for the package sources. Bindings of type ca-certificates hold
+// any number of PEM encoded CA certificates that are trusted for all sources.
+// Bindings of type poetry-certs hold the 'cert' and 'client-cert' for a single
+// source.
+var CertificateBindingTypes = []string{"ca-certificates", "poetry-certs"}
+
+// defaultCABundles are the locations of the system CA bundle that are tried
+// when SSL_CERT_FILE is not set.
+var defaultCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/cert.pem",
+}
+
+// sourceCertificates holds the certificates configured for the package
+// sources.
+type sourceCertificates struct {
+	// CABundle is the path of the CA bundle that is trusted for all sources,
+	// or empty when no additional CA certificates are configured.
+	CABundle string
+
+	// Certs and ClientCerts map source names to the paths of their CA
+	// certificate and client certificate.
+	Certs       map[string]string
+	ClientCerts map[string]string
+}
+
+// loadSourceCertificates collects the certificates provided by the given
+// service bindings and by the BP_POETRY_CA_CERTIFICATES and
+// BP_POETRY_CERTIFICATES_<SOURCE>_CERT/_CLIENT_CERT environment variables.
+// Certificates that are provided as binding entries are written to dir, as is
+// the CA bundle, which includes the system CA certificates so that it can
+// replace the default trust store.
+func loadSourceCertificates(bindings []servicebindings.Binding, sources []string, dir string) (sourceCertificates, error) {
+	certificates := sourceCertificates{
+		Certs:       map[string]string{},
+		ClientCerts: map[string]string{},
+	}
+
+	var caCertificates [][]byte
+	for _, binding := range bindings {
+		switch strings.ToLower(binding.Type) {
+		case "ca-certificates":
+			var keys []string
+			for key := range binding.Entries {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				content, err := binding.Entries[key].ReadBytes()
+				if err != nil {
+					return sourceCertificates{}, fmt.Errorf("failed to read '%s' of service binding '%s':\nerror: %w", key, binding.Name, err)
+				}
+				caCertificates = append(caCertificates, content)
+			}
+
+		case "poetry-certs":
+			source := binding.Name
+			if entry, ok := binding.Entries["source"]; ok {
+				value, err := entry.ReadString()
+				if err != nil {
+					return sourceCertificates{}, fmt.Errorf("failed to read 'source' of service binding '%s':\nerror: %w", binding.Name, err)
+				}
+				source = strings.TrimSpace(value)
+			}
+
+			if !slices.Contains(sources, source) {
+				return sourceCertificates{}, fmt.Errorf("service binding '%s' refers to source '%s' which is not declared in [[tool.poetry.source]], declared sources are: [%s]", binding.Name, source, strings.Join(sources, ", "))
+			}
+
+			for key, paths := range map[string]map[string]string{"cert": certificates.Certs, "client-cert": certificates.ClientCerts} {
+				entry, ok := binding.Entries[key]
+				if !ok {
+					continue
+				}
+
+				content, err := entry.ReadBytes()
+				if err != nil {
+					return sourceCertificates{}, fmt.Errorf("failed to read '%s' of service binding '%s':\nerror: %w", key, binding.Name, err)
+				}
+
+				path := filepath.Join(dir, fmt.Sprintf("%s-%s.pem", binding.Name, key))
+				if err := os.WriteFile(path, content, 0600); err != nil {
+					return sourceCertificates{}, err
+				}
+				paths[source] = path
+			}
+
+			if _, ok := certificates.Certs[source]; !ok {
+				if _, ok := certificates.ClientCerts[source]; !ok {
+					return sourceCertificates{}, fmt.Errorf("service binding '%s' must provide a 'cert' or a 'client-cert'", binding.Name)
+				}
+			}
+		}
+	}
+
+	if path, ok := os.LookupEnv("BP_POETRY_CA_CERTIFICATES"); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return sourceCertificates{}, fmt.Errorf("failed to read BP_POETRY_CA_CERTIFICATES:\nerror: %w", err)
+		}
+		caCertificates = append(caCertificates, content)
+	}
+
+	for _, source := range sources {
+		prefix := fmt.Sprintf("BP_POETRY_CERTIFICATES_%s", envName(source))
+		if path, ok := os.LookupEnv(prefix + "_CERT"); ok {
+			certificates.Certs[source] = path
+		}
+
+		if path, ok := os.LookupEnv(prefix + "_CLIENT_CERT"); ok {
+			certificates.ClientCerts[source] = path
+		}
+	}
+
+	if len(caCertificates) > 0 {
+		bundle, err := systemCABundle()
+		if err != nil {
+			return sourceCertificates{}, err
+		}
+
+		for _, content := range caCertificates {
+			bundle = append(bundle, '\n')
+			bundle = append(bundle, bytes.TrimSpace(content)...)
+			bundle = append(bundle, '\n')
+		}
+
+		certificates.CABundle = filepath.Join(dir, "ca-bundle.pem")
+		if err := os.WriteFile(certificates.CABundle, bundle, 0600); err != nil {
+			return sourceCertificates{}, err
+		}
+	}
+
+	return certificates, nil
+}
+
+// systemCABundle returns the content of the system CA bundle, or nothing when
+// there is none.
+func systemCABundle() ([]byte, error) {
+	paths := defaultCABundles
+	if path, ok := os.LookupEnv("SSL_CERT_FILE"); ok {
+		paths = []string{path}
+	}
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			return content, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read system CA bundle:\nerror: %w", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// Env returns the environment variables that configure poetry, and pip and
+// requests as used by poetry, with the certificates of the given sources.
+func (c sourceCertificates) Env(sources []string) []string {
+	var env []string
+	if c.CABundle != "" {
+		env = append(env,
+			fmt.Sprintf("REQUESTS_CA_BUNDLE=%s", c.CABundle),
+			fmt.Sprintf("SSL_CERT_FILE=%s", c.CABundle),
+			fmt.Sprintf("PIP_CERT=%s", c.CABundle),
+		)
+	}
+
+	for _, source := range sources {
+		cert, ok := c.Certs[source]
+		if !ok {
+			cert = c.CABundle
+		}
+
+		if cert != "" {
+			env = append(env, fmt.Sprintf("POETRY_CERTIFICATES_%s_CERT=%s", envName(source), cert))
+		}
+
+		if clientCert, ok := c.ClientCerts[source]; ok {
+			env = append(env, fmt.Sprintf("POETRY_CERTIFICATES_%s_CLIENT_CERT=%s", envName(source), clientCert))
+		}
+	}
+
+	return env
+}
+
+// Sources returns the names of the sources that have certificates of their
+// own, sorted by name.
+func (c sourceCertificates) Sources() []string {
+	var sources []string
+	for source := range c.Certs {
+		sources = appendUnique(sources, source)
+	}
+
+	for source := range c.ClientCerts {
+		sources = appendUnique(sources, source)
+	}
+	sort.Strings(sources)
+
+	return sources
+}
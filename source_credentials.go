@@ -0,0 +1,90 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
+
+// SourceCredentialBindingTypes are the service binding types that provide
+// credentials for the package sources declared in [[tool.poetry.source]].
+var SourceCredentialBindingTypes = []string{"poetry", "pypi-repository"}
+
+var envNamePattern = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// sourceCredentials returns the environment variables that configure poetry
+// with the credentials provided by the given service bindings. A binding
+// applies to the source named by its 'source' entry, or to the source with
+// the same name as the binding. It provides either a 'username' and a
+// 'password', or a 'token'. The names of the sources that have credentials
+// are returned along with the environment variables, which must not be
+// logged.
+func sourceCredentials(bindings []servicebindings.Binding, sources []string) ([]string, []string, error) {
+	var (
+		env   []string
+		names []string
+	)
+
+	for _, binding := range bindings {
+		if !slices.Contains(SourceCredentialBindingTypes, strings.ToLower(binding.Type)) {
+			continue
+		}
+
+		entries := map[string]string{}
+		for _, key := range []string{"source", "username", "password", "token"} {
+			entry, ok := binding.Entries[key]
+			if !ok {
+				continue
+			}
+
+			value, err := entry.ReadString()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read '%s' of service binding '%s':\nerror: %w", key, binding.Name, err)
+			}
+			entries[key] = strings.TrimSpace(value)
+		}
+
+		source := entries["source"]
+		if source == "" {
+			source = binding.Name
+		}
+
+		if !slices.Contains(sources, source) {
+			return nil, nil, fmt.Errorf("service binding '%s' refers to source '%s' which is not declared in [[tool.poetry.source]], declared sources are: [%s]", binding.Name, source, strings.Join(sources, ", "))
+		}
+
+		name := envName(source)
+		switch {
+		case entries["token"] != "":
+			// Poetry only reads pypi-token credentials when publishing, so the
+			// token is also passed as http-basic credentials for installation,
+			// as expected by indexes that accept API tokens.
+			env = append(env,
+				fmt.Sprintf("POETRY_PYPI_TOKEN_%s=%s", name, entries["token"]),
+				fmt.Sprintf("POETRY_HTTP_BASIC_%s_USERNAME=__token__", name),
+				fmt.Sprintf("POETRY_HTTP_BASIC_%s_PASSWORD=%s", name, entries["token"]),
+			)
+		case entries["username"] != "" && entries["password"] != "":
+			env = append(env,
+				fmt.Sprintf("POETRY_HTTP_BASIC_%s_USERNAME=%s", name, entries["username"]),
+				fmt.Sprintf("POETRY_HTTP_BASIC_%s_PASSWORD=%s", name, entries["password"]),
+			)
+		default:
+			return nil, nil, fmt.Errorf("service binding '%s' must provide either 'username' and 'password' or 'token'", binding.Name)
+		}
+
+		names = appendUnique(names, source)
+	}
+
+	return env, names, nil
+}
+
+// envName returns a name, such as the name of a source, as used in the names
+// of the environment variables that configure it, for example "my-index"
+// becomes "MY_INDEX".
+func envName(source string) string {
+	return envNamePattern.ReplaceAllString(strings.ToUpper(source), "_")
+}
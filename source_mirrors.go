@@ -0,0 +1,325 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
+
+// SourceMirrorBindingTypes are the service binding types that provide a
+// replacement URL for a package source. A binding holds the 'url' of the
+// mirror and the 'source' it replaces.
+var SourceMirrorBindingTypes = []string{"poetry-mirror"}
+
+// PyPIMirrorSource is the name of the package source that replaces PyPI when
+// PyPI is mirrored.
+const PyPIMirrorSource = "pypi-mirror"
+
+// pypiURLs are the URLs of PyPI that refer to the implicit PyPI source when
+// used as the source of a mirror.
+var pypiURLs = []string{"https://pypi.org/simple", "https://pypi.org/pypi", "https://pypi.python.org/simple"}
+
+// sourceMirror replaces the URL of a package source for the install.
+type sourceMirror struct {
+	Source string
+	URL    string
+}
+
+// String returns the mirror as it is logged, with any credentials in the URL
+// hidden.
+func (m sourceMirror) String() string {
+	mirrorURL := m.URL
+	if parsed, err := url.Parse(m.URL); err == nil {
+		mirrorURL = parsed.Redacted()
+	}
+
+	return fmt.Sprintf("%s -> %s", m.Source, mirrorURL)
+}
+
+// loadSourceMirrors returns the mirrors configured by the given service
+// bindings and by BP_POETRY_SOURCE_MIRRORS, a comma-separated list of
+// <source>=<url> pairs that take precedence over the bindings, sorted by
+// source. A mirror applies to a source declared in [[tool.poetry.source]],
+// given by its name or its URL, or to PyPI, given as "pypi" or by one of its
+// URLs.
+func loadSourceMirrors(bindings []servicebindings.Binding, pyProject PyProject) ([]sourceMirror, error) {
+	mirrors := map[string]string{}
+
+	for _, binding := range bindings {
+		if !slices.Contains(SourceMirrorBindingTypes, strings.ToLower(binding.Type)) {
+			continue
+		}
+
+		entries := map[string]string{"source": binding.Name}
+		for _, key := range []string{"source", "url"} {
+			entry, ok := binding.Entries[key]
+			if !ok {
+				continue
+			}
+
+			value, err := entry.ReadString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read '%s' of service binding '%s':\nerror: %w", key, binding.Name, err)
+			}
+			entries[key] = strings.TrimSpace(value)
+		}
+
+		if entries["url"] == "" {
+			return nil, fmt.Errorf("service binding '%s' must provide a 'url'", binding.Name)
+		}
+
+		source, err := mirroredSource(entries["source"], pyProject)
+		if err != nil {
+			return nil, fmt.Errorf("service binding '%s' %w", binding.Name, err)
+		}
+		mirrors[source] = entries["url"]
+	}
+
+	for _, pair := range splitList(os.Getenv("BP_POETRY_SOURCE_MIRRORS")) {
+		key, mirrorURL, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(mirrorURL) == "" {
+			return nil, fmt.Errorf("failed to parse BP_POETRY_SOURCE_MIRRORS entry '%s': expected <source>=<url>", pair)
+		}
+
+		source, err := mirroredSource(strings.TrimSpace(key), pyProject)
+		if err != nil {
+			return nil, fmt.Errorf("BP_POETRY_SOURCE_MIRRORS entry '%s' %w", pair, err)
+		}
+		mirrors[source] = strings.TrimSpace(mirrorURL)
+	}
+
+	var result []sourceMirror
+	for source, mirrorURL := range mirrors {
+		result = append(result, sourceMirror{Source: source, URL: mirrorURL})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Source < result[j].Source
+	})
+
+	return result, nil
+}
+
+// mirroredSource returns the name of the source that is referred to by the
+// given name or URL, which is "pypi" for PyPI.
+func mirroredSource(key string, pyProject PyProject) (string, error) {
+	normalized := strings.TrimSuffix(key, "/")
+	if strings.EqualFold(key, "pypi") || slices.Contains(pypiURLs, normalized) {
+		return "pypi", nil
+	}
+
+	for _, source := range pyProject.Tool.Poetry.Source {
+		if strings.EqualFold(source.Name, "pypi") {
+			continue
+		}
+
+		if source.Name == key || (source.URL != "" && strings.TrimSuffix(source.URL, "/") == normalized) {
+			return source.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("refers to source '%s' which is neither PyPI nor declared in [[tool.poetry.source]], declared sources are: [%s]", key, strings.Join(pyProject.Sources(), ", "))
+}
+
+// mirroredProject creates a copy of the project in workingDir in which the
+// URLs of the mirrored sources in pyproject.toml are replaced, and a source
+// that replaces PyPI is added when PyPI is mirrored, so that the files of the
+// app are left unchanged. The other files of the project are linked into the
+// copy, except for poetry.lock which is copied, and relative path
+// dependencies are made absolute so that they still refer to workingDir. It
+// returns the directory of the copy, which the caller removes.
+func mirroredProject(workingDir string, mirrors []sourceMirror, capabilities poetryCapabilities) (string, error) {
+	var pyProject map[string]interface{}
+	if _, err := toml.DecodeFile(filepath.Join(workingDir, "pyproject.toml"), &pyProject); err != nil {
+		return "", fmt.Errorf("failed to parse pyproject.toml:\nerror: %w", err)
+	}
+
+	tool, _ := pyProject["tool"].(map[string]interface{})
+	if tool == nil {
+		tool = map[string]interface{}{}
+		pyProject["tool"] = tool
+	}
+
+	poetry, _ := tool["poetry"].(map[string]interface{})
+	if poetry == nil {
+		poetry = map[string]interface{}{}
+		tool["poetry"] = poetry
+	}
+
+	sources, _ := poetry["source"].([]map[string]interface{})
+	for _, mirror := range mirrors {
+		if mirror.Source != "pypi" {
+			for _, source := range sources {
+				if source["name"] == mirror.Source {
+					source["url"] = mirror.URL
+				}
+			}
+			continue
+		}
+
+		index := slices.IndexFunc(sources, func(source map[string]interface{}) bool {
+			name, _ := source["name"].(string)
+			return strings.EqualFold(name, "pypi")
+		})
+
+		if index >= 0 {
+			sources[index]["name"] = PyPIMirrorSource
+			sources[index]["url"] = mirror.URL
+			continue
+		}
+
+		for _, source := range sources {
+			priority, hasPriority := source["priority"].(string)
+			if source["default"] == true || priority == "default" || (capabilities.primaryReplacesPyPI && (!hasPriority || priority == "primary")) {
+				return "", fmt.Errorf("PyPI cannot be mirrored: it is not used because source '%v' replaces it", source["name"])
+			}
+		}
+
+		pypiMirror := map[string]interface{}{
+			"name": PyPIMirrorSource,
+			"url":  mirror.URL,
+		}
+		for key, value := range capabilities.pypiMirror {
+			pypiMirror[key] = value
+		}
+		sources = append([]map[string]interface{}{pypiMirror}, sources...)
+	}
+	if len(sources) > 0 {
+		poetry["source"] = sources
+	}
+
+	dependencyTables := []interface{}{poetry["dependencies"], poetry["dev-dependencies"]}
+	groups, _ := poetry["group"].(map[string]interface{})
+	for _, group := range groups {
+		if group, ok := group.(map[string]interface{}); ok {
+			dependencyTables = append(dependencyTables, group["dependencies"])
+		}
+	}
+
+	for _, table := range dependencyTables {
+		dependencies, _ := table.(map[string]interface{})
+		for _, dependency := range dependencies {
+			absolutePathDependency(dependency, workingDir)
+		}
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := toml.NewEncoder(buffer).Encode(pyProject); err != nil {
+		return "", fmt.Errorf("failed to write pyproject.toml:\nerror: %w", err)
+	}
+
+	projectDir, err := os.MkdirTemp("", "poetry-mirrored-project")
+	if err != nil {
+		return "", err
+	}
+
+	err = linkProject(workingDir, projectDir, buffer.Bytes())
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("failed to copy the project to mirror its sources:\nerror: %w", err), os.RemoveAll(projectDir))
+	}
+
+	return projectDir, nil
+}
+
+// absolutePathDependency makes the path of a path dependency, given as a table
+// or as a list of tables with markers, absolute against workingDir.
+func absolutePathDependency(dependency interface{}, workingDir string) {
+	switch dependency := dependency.(type) {
+	case map[string]interface{}:
+		if path, ok := dependency["path"].(string); ok && !filepath.IsAbs(path) {
+			dependency["path"] = filepath.Join(workingDir, path)
+		}
+	case []map[string]interface{}:
+		for _, constraint := range dependency {
+			absolutePathDependency(constraint, workingDir)
+		}
+	case []interface{}:
+		for _, constraint := range dependency {
+			absolutePathDependency(constraint, workingDir)
+		}
+	}
+}
+
+// linkProject fills projectDir with links to the files of the project in
+// workingDir, with the given content for pyproject.toml and a copy of
+// poetry.lock, which poetry rewrites.
+func linkProject(workingDir, projectDir string, pyProject []byte) error {
+	entries, err := os.ReadDir(workingDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch entry.Name() {
+		case "pyproject.toml":
+			err = os.WriteFile(filepath.Join(projectDir, entry.Name()), pyProject, 0644)
+		case "poetry.lock":
+			var lock []byte
+			lock, err = os.ReadFile(filepath.Join(workingDir, entry.Name()))
+			if err == nil {
+				err = os.WriteFile(filepath.Join(projectDir, entry.Name()), lock, 0644)
+			}
+		default:
+			err = os.Symlink(filepath.Join(workingDir, entry.Name()), filepath.Join(projectDir, entry.Name()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyMirroredLock checks that the poetry.lock that was updated for the
+// mirrored sources locks the same versions of the same packages as the
+// original, with hashes that the original records, so that the mirrors cannot
+// change what is installed. A package can be locked at several versions for
+// different environment markers.
+func verifyMirroredLock(original, mirrored PoetryLock) error {
+	locked := map[lockedPackageKey]PoetryLockPackage{}
+	for _, lockPackage := range original.Packages {
+		locked[lockedPackageKey{normalizeName(lockPackage.Name), lockPackage.Version}] = lockPackage
+	}
+
+	var changed []string
+	for _, lockPackage := range mirrored.Packages {
+		key := lockedPackageKey{normalizeName(lockPackage.Name), lockPackage.Version}
+		originalPackage, ok := locked[key]
+		if !ok {
+			changed = append(changed, fmt.Sprintf("%s==%s", lockPackage.Name, lockPackage.Version))
+			continue
+		}
+
+		hashes := map[string]bool{}
+		for _, file := range originalPackage.Files {
+			hashes[file.Hash] = true
+		}
+
+		for _, file := range lockPackage.Files {
+			if !hashes[file.Hash] {
+				changed = append(changed, fmt.Sprintf("%s (%s)", file.File, file.Hash))
+			}
+		}
+		delete(locked, key)
+	}
+
+	for _, lockPackage := range locked {
+		changed = append(changed, fmt.Sprintf("%s==%s", lockPackage.Name, lockPackage.Version))
+	}
+
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		return fmt.Errorf("the mirrored sources do not provide the packages locked in poetry.lock, differences: [%s]", strings.Join(changed, ", "))
+	}
+
+	return nil
+}
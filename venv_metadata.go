@@ -0,0 +1,130 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// venvMetadata holds the inputs that determine the contents of the
+// poetry-venv layer. It is recorded in the layer metadata so that subsequent
+// builds can decide whether the cached layer can be reused as-is.
+type venvMetadata struct {
+	PoetryLockSHA     string
+	PyProjectSHA      string
+	InstallGroups     string
+	InstallExtras     string
+	InstallRoot       string
+	PoetryConfig      string
+	CompileBytecode   string
+	CPythonVersion    string
+	VenvPrune         string
+	StripDebugSymbols string
+	LockPolicy        string
+	VenvDir           string
+}
+
+// newVenvMetadata returns the metadata for the project in workingDir with the
+// checksums of its poetry.lock and pyproject.toml files filled in.
+func newVenvMetadata(workingDir string) (venvMetadata, error) {
+	poetryLockSHA, err := fileChecksum(filepath.Join(workingDir, "poetry.lock"))
+	if err != nil {
+		return venvMetadata{}, err
+	}
+
+	pyProjectSHA, err := fileChecksum(filepath.Join(workingDir, "pyproject.toml"))
+	if err != nil {
+		return venvMetadata{}, err
+	}
+
+	return venvMetadata{
+		PoetryLockSHA: poetryLockSHA,
+		PyProjectSHA:  pyProjectSHA,
+	}, nil
+}
+
+// fileChecksum returns the checksum of the file at path, or an empty string
+// when the file does not exist.
+func fileChecksum(path string) (string, error) {
+	exists, err := fs.Exists(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", nil
+	}
+
+	return fs.NewChecksumCalculator().Sum(path)
+}
+
+// compare reports whether a layer carrying the given cached metadata can be
+// reused, along with a description of the reason.
+func (m venvMetadata) compare(cached map[string]interface{}) (bool, string) {
+	if len(cached) == 0 {
+		return false, "no cached layer metadata found"
+	}
+
+	if m.PoetryLockSHA == "" {
+		return false, "no 'poetry.lock' found"
+	}
+
+	var changes []string
+	if cached["poetry_lock_sha"] != m.PoetryLockSHA {
+		changes = append(changes, "poetry.lock changed")
+	}
+
+	if cached["pyproject_toml_sha"] != m.PyProjectSHA {
+		changes = append(changes, "pyproject.toml changed")
+	}
+
+	current := m.toMap()
+	for _, setting := range []struct{ key, description string }{
+		{"install_groups", "install groups"},
+		{"install_extras", "install extras"},
+		{"install_root", "root project installation"},
+		{"poetry_config", "poetry configuration"},
+		{"compile_bytecode", "bytecode compilation"},
+		{"cpython_version", "CPython version"},
+		{"venv_prune", "venv pruning"},
+		{"strip_debug_symbols", "debug symbol stripping"},
+		{"lock_policy", "lock policy"},
+	} {
+		previous, _ := cached[setting.key].(string)
+		if previous != current[setting.key] {
+			changes = append(changes, fmt.Sprintf("%s changed from '%s' to '%s'", setting.description, previous, current[setting.key]))
+		}
+	}
+
+	if len(changes) > 0 {
+		return false, strings.Join(changes, ", ")
+	}
+
+	venvDir, _ := cached["venv_dir"].(string)
+	exists, err := fs.Exists(venvDir)
+	if venvDir == "" || err != nil || !exists {
+		return false, "cached virtual environment not found"
+	}
+
+	return true, "poetry.lock, pyproject.toml, install settings and CPython version are unchanged"
+}
+
+// toMap returns the metadata in the form stored on the layer.
+func (m venvMetadata) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"poetry_lock_sha":     m.PoetryLockSHA,
+		"pyproject_toml_sha":  m.PyProjectSHA,
+		"install_groups":      m.InstallGroups,
+		"install_extras":      m.InstallExtras,
+		"install_root":        m.InstallRoot,
+		"poetry_config":       m.PoetryConfig,
+		"compile_bytecode":    m.CompileBytecode,
+		"cpython_version":     m.CPythonVersion,
+		"venv_prune":          m.VenvPrune,
+		"strip_debug_symbols": m.StripDebugSymbols,
+		"lock_policy":         m.LockPolicy,
+		"venv_dir":            m.VenvDir,
+	}
+}
@@ -0,0 +1,229 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// normalizedModTime is the modification time given to every file of the
+// virtual env. It is the 1980-01-01 00:00:01 that the lifecycle sets on the
+// files of the app image, so that bytecode that is validated by the timestamp
+// of its source stays valid in the image.
+var normalizedModTime = time.Unix(315532801, 0)
+
+// PoetryVenvNormalizer implements the VenvNormalizer interface.
+type PoetryVenvNormalizer struct{}
+
+// NewPoetryVenvNormalizer creates an instance of the PoetryVenvNormalizer.
+func NewPoetryVenvNormalizer() PoetryVenvNormalizer {
+	return PoetryVenvNormalizer{}
+}
+
+// Normalize removes the differences between two installs of the same packages
+// into the virtual env in venvDir, so that the layer has the same digest
+// every time it is built:
+//   - The direct_url.json files that refer to a local path outside of
+//     workingDir, such as a temporary directory, are removed.
+//   - The rows of the RECORD files are sorted, as installers write them in
+//     no particular order.
+//   - The source timestamp recorded in timestamp-based bytecode is set to the
+//     normalized modification time.
+//   - The modification time of every file and directory is set to the
+//     normalized modification time.
+func (n PoetryVenvNormalizer) Normalize(workingDir, venvDir string) error {
+	var records []string
+	removed := map[string][]string{}
+
+	err := filepath.WalkDir(venvDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		switch {
+		case entry.Name() == "RECORD" && strings.HasSuffix(filepath.Dir(path), ".dist-info"):
+			records = append(records, path)
+
+		case entry.Name() == "direct_url.json" && strings.HasSuffix(filepath.Dir(path), ".dist-info"):
+			local, err := isLocalDirectURL(path, workingDir)
+			if err != nil {
+				return err
+			}
+
+			if local {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+
+				distInfoDir := filepath.Dir(path)
+				removed[distInfoDir] = append(removed[distInfoDir], filepath.ToSlash(filepath.Join(filepath.Base(distInfoDir), entry.Name())))
+			}
+
+		case filepath.Ext(path) == ".pyc":
+			if err := normalizeBytecode(path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to normalize the virtual env:\nerror: %w", err)
+	}
+
+	for _, record := range records {
+		if err := sortRecord(record, removed[filepath.Dir(record)]); err != nil {
+			return fmt.Errorf("failed to normalize '%s':\nerror: %w", record, err)
+		}
+	}
+
+	// The modification times are set last, as the changes above update the
+	// modification time of the files and of their directories.
+	err = filepath.WalkDir(venvDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		return os.Chtimes(path, normalizedModTime, normalizedModTime)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to normalize the modification times of the virtual env:\nerror: %w", err)
+	}
+
+	return nil
+}
+
+// isLocalDirectURL reports whether the direct_url.json file at path refers
+// to a local path outside of workingDir, which is not the same from one
+// install to the next.
+func isLocalDirectURL(path, workingDir string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	var directURL struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(content, &directURL); err != nil {
+		return false, fmt.Errorf("failed to parse '%s': %w", path, err)
+	}
+
+	parsed, err := url.Parse(directURL.URL)
+	if err != nil || parsed.Scheme != "file" {
+		return false, nil
+	}
+
+	relative, err := filepath.Rel(workingDir, filepath.FromSlash(parsed.Path))
+	if err != nil {
+		return true, nil
+	}
+
+	return relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)), nil
+}
+
+// sortRecord sorts the rows of the RECORD file at path by the path of the
+// file they describe, leaving out the rows of the removed files.
+func sortRecord(path string, removed []string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	var kept [][]string
+	for _, row := range rows {
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+
+		if !slices.Contains(removed, row[0]) {
+			kept = append(kept, row)
+		}
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i][0] < kept[j][0]
+	})
+
+	buffer := bytes.NewBuffer(nil)
+	writer := csv.NewWriter(buffer)
+	writer.UseCRLF = bytes.Contains(content, []byte("\r\n"))
+	if err := writer.WriteAll(kept); err != nil {
+		return err
+	}
+
+	if bytes.Equal(buffer.Bytes(), content) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buffer.Bytes(), info.Mode())
+}
+
+// normalizeBytecode sets the source timestamp in the header of the .pyc file
+// at path to the normalized modification time. Bytecode that is validated by
+// the hash of its source, as written when SOURCE_DATE_EPOCH is set, is left
+// unchanged.
+func normalizeBytecode(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The header of a .pyc file is made of the magic number, a bit field
+	// whose lowest bit is set for hash-based bytecode, and then either the
+	// timestamp and the size of the source or the hash of the source.
+	header := make([]byte, 16)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(header[4:8])&1 != 0 {
+		return nil
+	}
+
+	timestamp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(timestamp, uint32(normalizedModTime.Unix()))
+	if bytes.Equal(header[8:12], timestamp) {
+		return nil
+	}
+
+	_, err = file.WriteAt(timestamp, 8)
+	return err
+}
@@ -0,0 +1,238 @@
+package poetryinstall_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	poetryinstall "github.com/paketo-buildpacks/poetry-install"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testVenvNormalizer(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		workingDir      string
+		venvDir         string
+		sitePackagesDir string
+		distInfoDir     string
+
+		normalizer poetryinstall.PoetryVenvNormalizer
+	)
+
+	// pyc returns the content of a .pyc file with the given flags and source
+	// timestamp in its header.
+	pyc := func(flags uint32, timestamp time.Time) []byte {
+		content := []byte{0xcb, 0x0d, 0x0d, 0x0a}
+		content = binary.LittleEndian.AppendUint32(content, flags)
+		content = binary.LittleEndian.AppendUint32(content, uint32(timestamp.Unix()))
+		content = binary.LittleEndian.AppendUint32(content, 42)
+		return append(content, []byte("some-bytecode")...)
+	}
+
+	// install writes a virtual env as an installer would, with the RECORD rows
+	// in the given order and the given temporary directory in direct_url.json.
+	install := func(recordRows []string, tmpDir string) {
+		Expect(os.RemoveAll(venvDir)).To(Succeed())
+
+		Expect(os.MkdirAll(filepath.Join(venvDir, "bin"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sitePackagesDir, "flask", "__pycache__"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(distInfoDir, os.ModePerm)).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(venvDir, "pyvenv.cfg"), []byte("home = /some/python/bin\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(venvDir, "bin", "flask"), []byte("#!/some/venv/bin/python\n"), 0755)).To(Succeed())
+		Expect(os.Symlink("python3.12", filepath.Join(venvDir, "bin", "python"))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "__init__.py"), []byte("import os\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc"), pyc(0, time.Now()), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(distInfoDir, "METADATA"), []byte("Name: flask\nVersion: 3.0.3\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(distInfoDir, "direct_url.json"), []byte(fmt.Sprintf(`{"url": "file://%s/flask-3.0.3-py3-none-any.whl", "archive_info": {}}`, tmpDir)), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(distInfoDir, "RECORD"), []byte(strings.Join(recordRows, "\n")+"\n"), 0644)).To(Succeed())
+	}
+
+	// digest returns a digest of the path, mode, modification time and
+	// content of every file in the virtual env.
+	digest := func() string {
+		hash := sha256.New()
+		err := filepath.WalkDir(venvDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			info, err := os.Lstat(path)
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(venvDir, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s %s", rel, info.Mode())
+
+			switch {
+			case info.Mode()&fs.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, " %s", target)
+			case info.Mode().IsRegular():
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, " %d %x", info.ModTime().Unix(), content)
+			default:
+				fmt.Fprintf(hash, " %d", info.ModTime().Unix())
+			}
+			fmt.Fprintln(hash)
+
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	it.Before(func() {
+		var err error
+		workingDir, err = os.MkdirTemp("", "working-dir")
+		Expect(err).NotTo(HaveOccurred())
+
+		venvDir = filepath.Join(workingDir, "..", filepath.Base(workingDir)+"-venv")
+		sitePackagesDir = filepath.Join(venvDir, "lib", "python3.12", "site-packages")
+		distInfoDir = filepath.Join(sitePackagesDir, "flask-3.0.3.dist-info")
+
+		normalizer = poetryinstall.NewPoetryVenvNormalizer()
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(workingDir)).To(Succeed())
+		Expect(os.RemoveAll(venvDir)).To(Succeed())
+	})
+
+	it("gives the same virtual env when the same packages are installed twice", func() {
+		install([]string{
+			"flask/__init__.py,sha256=abc,10",
+			"flask/__pycache__/__init__.cpython-312.pyc,,",
+			"flask-3.0.3.dist-info/METADATA,sha256=def,27",
+			"flask-3.0.3.dist-info/direct_url.json,sha256=ghi,80",
+			"flask-3.0.3.dist-info/RECORD,,",
+			"../../../bin/flask,sha256=jkl,24",
+		}, "/tmp/some-tmp-dir")
+		Expect(normalizer.Normalize(workingDir, venvDir)).To(Succeed())
+		first := digest()
+
+		time.Sleep(1100 * time.Millisecond)
+
+		install([]string{
+			"../../../bin/flask,sha256=jkl,24",
+			"flask-3.0.3.dist-info/RECORD,,",
+			"flask-3.0.3.dist-info/direct_url.json,sha256=mno,83",
+			"flask/__pycache__/__init__.cpython-312.pyc,,",
+			"flask-3.0.3.dist-info/METADATA,sha256=def,27",
+			"flask/__init__.py,sha256=abc,10",
+		}, "/tmp/some-other-tmp-dir")
+		Expect(normalizer.Normalize(workingDir, venvDir)).To(Succeed())
+
+		Expect(digest()).To(Equal(first))
+
+		Expect(filepath.Join(distInfoDir, "direct_url.json")).NotTo(BeAnExistingFile())
+
+		record, err := os.ReadFile(filepath.Join(distInfoDir, "RECORD"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(record)).To(Equal(strings.Join([]string{
+			"../../../bin/flask,sha256=jkl,24",
+			"flask-3.0.3.dist-info/METADATA,sha256=def,27",
+			"flask-3.0.3.dist-info/RECORD,,",
+			"flask/__init__.py,sha256=abc,10",
+			"flask/__pycache__/__init__.cpython-312.pyc,,",
+		}, "\n") + "\n"))
+
+		bytecode, err := os.ReadFile(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bytecode).To(Equal(pyc(0, time.Unix(315532801, 0))))
+
+		info, err := os.Stat(filepath.Join(sitePackagesDir, "flask", "__init__.py"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ModTime()).To(Equal(time.Unix(315532801, 0)))
+	})
+
+	it("keeps the direct_url.json of a package installed from the app", func() {
+		install([]string{"flask-3.0.3.dist-info/direct_url.json,sha256=ghi,80"}, workingDir)
+		Expect(normalizer.Normalize(workingDir, venvDir)).To(Succeed())
+
+		Expect(filepath.Join(distInfoDir, "direct_url.json")).To(BeAnExistingFile())
+
+		record, err := os.ReadFile(filepath.Join(distInfoDir, "RECORD"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(record)).To(Equal("flask-3.0.3.dist-info/direct_url.json,sha256=ghi,80\n"))
+	})
+
+	it("leaves hash-based bytecode unchanged", func() {
+		install(nil, "/tmp/some-tmp-dir")
+
+		path := filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc")
+		timestamp := time.Unix(1700000000, 0)
+		Expect(os.WriteFile(path, pyc(1, timestamp), 0644)).To(Succeed())
+
+		Expect(normalizer.Normalize(workingDir, venvDir)).To(Succeed())
+
+		bytecode, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bytecode).To(Equal(pyc(1, timestamp)))
+	})
+
+	it("keeps the line endings of a RECORD file", func() {
+		install(nil, "/tmp/some-tmp-dir")
+		Expect(os.WriteFile(filepath.Join(distInfoDir, "RECORD"), []byte("flask/__init__.py,sha256=abc,10\r\nflask-3.0.3.dist-info/METADATA,sha256=def,27\r\n"), 0644)).To(Succeed())
+
+		Expect(normalizer.Normalize(workingDir, venvDir)).To(Succeed())
+
+		record, err := os.ReadFile(filepath.Join(distInfoDir, "RECORD"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(record)).To(Equal("flask-3.0.3.dist-info/METADATA,sha256=def,27\r\nflask/__init__.py,sha256=abc,10\r\n"))
+	})
+
+	context("failure cases", func() {
+		it("returns an error when the virtual env does not exist", func() {
+			err := normalizer.Normalize(workingDir, venvDir)
+			Expect(err).To(MatchError(ContainSubstring("failed to normalize the virtual env")))
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+
+		it("returns an error when a direct_url.json file is malformed", func() {
+			install(nil, "/tmp/some-tmp-dir")
+			Expect(os.WriteFile(filepath.Join(distInfoDir, "direct_url.json"), []byte("%%%"), 0644)).To(Succeed())
+
+			err := normalizer.Normalize(workingDir, venvDir)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to parse '%s'", filepath.Join(distInfoDir, "direct_url.json")))))
+		})
+
+		it("returns an error when a RECORD file is malformed", func() {
+			install(nil, "/tmp/some-tmp-dir")
+			Expect(os.WriteFile(filepath.Join(distInfoDir, "RECORD"), []byte("\"unterminated\n"), 0644)).To(Succeed())
+
+			err := normalizer.Normalize(workingDir, venvDir)
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to normalize '%s'", filepath.Join(distInfoDir, "RECORD")))))
+		})
+
+		it("returns an error when the bytecode cannot be written", func() {
+			install(nil, "/tmp/some-tmp-dir")
+			Expect(os.Chmod(filepath.Join(sitePackagesDir, "flask", "__pycache__", "__init__.cpython-312.pyc"), 0444)).To(Succeed())
+
+			err := normalizer.Normalize(workingDir, venvDir)
+			Expect(err).To(MatchError(ContainSubstring("permission denied")))
+		})
+	})
+}
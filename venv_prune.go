@@ -0,0 +1,242 @@
+package poetryinstall
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultPrunePatterns are the paths removed from the virtual env when
+// BP_POETRY_VENV_PRUNE is set and BP_POETRY_VENV_PRUNE_PATTERNS is not: the
+// tests of the packages, the RECORD files of their dist-info directories, C
+// headers and static libraries, none of which are needed to run the app.
+var defaultPrunePatterns = []string{"tests", "**/*.dist-info/RECORD", "*.h", "*.a"}
+
+// venvPrune returns whether the virtual env is pruned, as configured by
+// BP_POETRY_VENV_PRUNE, and the patterns of the paths that are removed, as
+// configured by BP_POETRY_VENV_PRUNE_PATTERNS.
+func venvPrune() (bool, []string, error) {
+	value, exists := os.LookupEnv("BP_POETRY_VENV_PRUNE")
+	if !exists {
+		return false, nil, nil
+	}
+
+	prune, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to parse BP_POETRY_VENV_PRUNE value '%s': %w", value, err)
+	}
+
+	if !prune {
+		return false, nil, nil
+	}
+
+	patterns := splitList(os.Getenv("BP_POETRY_VENV_PRUNE_PATTERNS"))
+	if len(patterns) == 0 {
+		return true, defaultPrunePatterns, nil
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return false, nil, fmt.Errorf("failed to parse BP_POETRY_VENV_PRUNE_PATTERNS value '%s': %w", pattern, err)
+		}
+	}
+
+	return true, patterns, nil
+}
+
+// pruneVenv removes the paths of the virtual env in venvDir that match one of
+// the patterns, along with the bytecode in __pycache__ directories that was
+// compiled for a python other than the one with the given version. A pattern
+// without a slash matches the name of a file or directory anywhere in the
+// virtual env, and a pattern with a slash matches its path relative to
+// venvDir, with ** standing for any number of directories. It returns the
+// removed paths, relative to venvDir, and the number of bytes they took. The
+// modification times of the directories are kept, so that the layer stays
+// reproducible.
+func pruneVenv(venvDir string, patterns []string, cpythonVersion string) ([]string, int64, error) {
+	cacheTag := bytecodeCacheTag(cpythonVersion)
+
+	var pruned []string
+	err := filepath.WalkDir(venvDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if filePath == venvDir {
+			return nil
+		}
+
+		relative, err := filepath.Rel(venvDir, filePath)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		if !matchesPrunePattern(patterns, relative) && !isStaleBytecode(relative, cacheTag) {
+			return nil
+		}
+
+		pruned = append(pruned, relative)
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to prune the virtual env:\nerror: %w", err)
+	}
+
+	var saved int64
+	for _, relative := range pruned {
+		filePath := filepath.Join(venvDir, filepath.FromSlash(relative))
+
+		size, err := diskUsage(filePath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to prune '%s':\nerror: %w", filePath, err)
+		}
+
+		parentInfo, err := os.Stat(filepath.Dir(filePath))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to prune '%s':\nerror: %w", filePath, err)
+		}
+
+		if err := os.RemoveAll(filePath); err != nil {
+			return nil, 0, fmt.Errorf("failed to prune '%s':\nerror: %w", filePath, err)
+		}
+
+		if err := os.Chtimes(filepath.Dir(filePath), parentInfo.ModTime(), parentInfo.ModTime()); err != nil {
+			return nil, 0, fmt.Errorf("failed to prune '%s':\nerror: %w", filePath, err)
+		}
+
+		saved += size
+	}
+
+	sort.Strings(pruned)
+
+	return pruned, saved, nil
+}
+
+// matchesPrunePattern reports whether the path, relative to the virtual env,
+// matches one of the patterns.
+func matchesPrunePattern(patterns []string, relative string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(relative)); matched {
+				return true
+			}
+			continue
+		}
+
+		if matchSegments(strings.Split(pattern, "/"), strings.Split(relative, "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSegments reports whether the segments of a path match the segments of
+// a pattern, where a ** segment matches any number of path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+// bytecodeCacheTag returns the tag that CPython of the given version puts in
+// the names of the .pyc files it writes, such as cpython-312 for 3.12.4, or
+// an empty string when the version cannot be parsed.
+func bytecodeCacheTag(cpythonVersion string) string {
+	parts := strings.Split(cpythonVersion, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	for _, part := range parts[:2] {
+		if _, err := strconv.Atoi(part); err != nil {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("cpython-%s%s", parts[0], parts[1])
+}
+
+// isStaleBytecode reports whether the path, relative to the virtual env, is a
+// .pyc file in a __pycache__ directory with a cache tag other than the given
+// one. Nothing is stale when the cache tag is unknown.
+func isStaleBytecode(relative, cacheTag string) bool {
+	if cacheTag == "" || path.Ext(relative) != ".pyc" || path.Base(path.Dir(relative)) != "__pycache__" {
+		return false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(path.Base(relative), ".pyc"), ".")
+	if len(parts) < 2 {
+		return false
+	}
+
+	return parts[1] != cacheTag
+}
+
+// diskUsage returns the size of the regular files at path, including those in
+// it when it is a directory.
+func diskUsage(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
+
+// formatSize returns the number of bytes in a form that is easy to read, such
+// as 12.3 MB.
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,281 @@
+package poetryinstall
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+var (
+	pyvenvHomePattern    = regexp.MustCompile(`(?m)^home\s*=\s*(.*?)\s*$`)
+	pyvenvVersionPattern = regexp.MustCompile(`(?m)^(?:version_info|version)\s*=\s*(\d+\.\d+)`)
+)
+
+// relocateVenv checks that the python interpreter of the virtual env in
+// venvDir, given by the home of its pyvenv.cfg, and the interpreters in the
+// shebangs of the scripts in its bin directory are available at launch. The
+// venvLayerPath is the path of the layer of the virtual env, and layersDir
+// the directory of the layers of all buildpacks. A home that is not available
+// at launch is replaced by a python of the same version from a launch layer,
+// and a shebang by the python of the virtual env. The modification times of
+// the rewritten files are kept, so that the layer stays reproducible.
+func relocateVenv(venvDir, venvLayerPath, layersDir string, logger scribe.Emitter) error {
+	pyvenvCfgPath := filepath.Join(venvDir, "pyvenv.cfg")
+	pyvenvCfg, err := os.ReadFile(pyvenvCfgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var rewritten []string
+
+	match := pyvenvHomePattern.FindSubmatch(pyvenvCfg)
+	if match != nil && !availableAtLaunch(string(match[1]), venvLayerPath, layersDir) {
+		home := string(match[1])
+
+		var version string
+		if versionMatch := pyvenvVersionPattern.FindSubmatch(pyvenvCfg); versionMatch != nil {
+			version = string(versionMatch[1])
+		}
+
+		launchHome, err := findLaunchPython(version, venvLayerPath, layersDir)
+		if err != nil {
+			return err
+		}
+
+		if launchHome == "" {
+			logger.Process("Warning: the python interpreter of the virtual env in '%s' is not available at launch", home)
+			logger.Subprocess("Require cpython at launch for the virtual env to work in the app image")
+			logger.Break()
+			return nil
+		}
+
+		pyvenvCfg = pyvenvHomePattern.ReplaceAll(pyvenvCfg, []byte(fmt.Sprintf("home = %s", launchHome)))
+		if err := rewriteFile(pyvenvCfgPath, pyvenvCfg); err != nil {
+			return fmt.Errorf("failed to rewrite pyvenv.cfg:\nerror: %w", err)
+		}
+		rewritten = append(rewritten, fmt.Sprintf("pyvenv.cfg: home %s -> %s", home, launchHome))
+
+		links, err := relinkVenvPythons(venvDir, launchHome, venvLayerPath, layersDir)
+		if err != nil {
+			return err
+		}
+		rewritten = append(rewritten, links...)
+	}
+
+	scripts, err := rewriteShebangs(venvDir, venvLayerPath, layersDir)
+	if err != nil {
+		return err
+	}
+	rewritten = append(rewritten, scripts...)
+
+	if len(rewritten) > 0 {
+		logger.Process("Relocating the virtual env, as it refers to paths that are not available at launch")
+		for _, line := range rewritten {
+			logger.Subprocess(line)
+		}
+		logger.Break()
+	}
+
+	return nil
+}
+
+// availableAtLaunch reports whether path, with its symlinks resolved, is part
+// of the app image. A path in a layer is part of the app image when the layer
+// is the layer of the virtual env or is marked as a launch layer. Paths
+// outside of the layers, such as those of the stack or of the app, are
+// assumed to be part of the app image.
+func availableAtLaunch(path, venvLayerPath, layersDir string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	if isWithin(resolved, venvLayerPath) {
+		return true
+	}
+
+	if !isWithin(resolved, layersDir) {
+		return true
+	}
+
+	relative, err := filepath.Rel(layersDir, resolved)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(relative, string(filepath.Separator))
+	if len(parts) < 2 {
+		return false
+	}
+
+	var layer struct {
+		Types struct {
+			Launch bool `toml:"launch"`
+		} `toml:"types"`
+	}
+	if _, err := toml.DecodeFile(filepath.Join(layersDir, parts[0], fmt.Sprintf("%s.toml", parts[1])), &layer); err != nil {
+		return false
+	}
+
+	return layer.Types.Launch
+}
+
+// isWithin reports whether path is dir or inside of dir.
+func isWithin(path, dir string) bool {
+	relative, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// findLaunchPython returns the bin directory of a python of the given
+// version in a launch layer, or an empty string when there is none.
+func findLaunchPython(version, venvLayerPath, layersDir string) (string, error) {
+	if version == "" {
+		return "", nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(layersDir, "*", "*", "bin", fmt.Sprintf("python%s", version)))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(matches)
+
+	for _, match := range matches {
+		if !isWithin(match, venvLayerPath) && availableAtLaunch(match, venvLayerPath, layersDir) {
+			return filepath.Dir(match), nil
+		}
+	}
+
+	return "", nil
+}
+
+// relinkVenvPythons points the python symlinks in the bin directory of the
+// virtual env that are not available at launch to the interpreter of the
+// same name in home.
+func relinkVenvPythons(venvDir, home, venvLayerPath, layersDir string) ([]string, error) {
+	binDir := filepath.Join(venvDir, "bin")
+	binInfo, err := os.Stat(binDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var relinked []string
+	for _, entry := range entries {
+		path := filepath.Join(binDir, entry.Name())
+		if entry.Type()&os.ModeSymlink == 0 || !strings.HasPrefix(entry.Name(), "python") || availableAtLaunch(path, venvLayerPath, layersDir) {
+			continue
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !filepath.IsAbs(target) {
+			continue
+		}
+
+		launchTarget := filepath.Join(home, filepath.Base(target))
+		if _, err := os.Stat(launchTarget); err != nil {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+
+		if err := os.Symlink(launchTarget, path); err != nil {
+			return nil, err
+		}
+		relinked = append(relinked, fmt.Sprintf("bin/%s: %s -> %s", entry.Name(), target, launchTarget))
+	}
+
+	if len(relinked) > 0 {
+		if err := os.Chtimes(binDir, binInfo.ModTime(), binInfo.ModTime()); err != nil {
+			return nil, err
+		}
+	}
+
+	return relinked, nil
+}
+
+// rewriteShebangs replaces the interpreters in the shebangs of the scripts in
+// the bin directory of the virtual env that are not available at launch by the
+// python of the virtual env.
+func rewriteShebangs(venvDir, venvLayerPath, layersDir string) ([]string, error) {
+	binDir := filepath.Join(venvDir, "bin")
+	venvPython := filepath.Join(binDir, "python")
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rewritten []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(binDir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasPrefix(content, []byte("#!")) {
+			continue
+		}
+
+		shebang, rest, _ := bytes.Cut(content, []byte("\n"))
+		interpreter := strings.Fields(strings.TrimPrefix(string(shebang), "#!"))
+		if len(interpreter) == 0 || !filepath.IsAbs(interpreter[0]) || availableAtLaunch(interpreter[0], venvLayerPath, layersDir) {
+			continue
+		}
+
+		shebang = bytes.Replace(shebang, []byte(interpreter[0]), []byte(venvPython), 1)
+		if err := rewriteFile(path, slices.Concat(shebang, []byte("\n"), rest)); err != nil {
+			return nil, fmt.Errorf("failed to rewrite the shebang of '%s':\nerror: %w", path, err)
+		}
+		rewritten = append(rewritten, fmt.Sprintf("bin/%s: %s -> %s", entry.Name(), interpreter[0], venvPython))
+	}
+
+	return rewritten, nil
+}
+
+// rewriteFile replaces the content of the file at path, keeping its mode and
+// modification time.
+func rewriteFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, content, info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
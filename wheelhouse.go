@@ -0,0 +1,257 @@
+package poetryinstall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// wheelhouseDir returns the directory of vendored distributions configured by
+// BP_POETRY_WHEELHOUSE, relative to workingDir, or an empty string when the
+// packages are installed from the package sources.
+func wheelhouseDir(workingDir string) string {
+	dir := os.Getenv("BP_POETRY_WHEELHOUSE")
+	if dir == "" || filepath.IsAbs(dir) {
+		return dir
+	}
+
+	return filepath.Join(workingDir, dir)
+}
+
+// wheelhouseRequirements returns the requirements, in the format of a pip
+// requirements file with hashes, of the locked packages that belong to the
+// given groups and extras. It verifies that the distributions of those
+// packages in dir match the hashes in poetry.lock, and returns an error that
+// lists the packages that have no distribution in dir. Packages that are only
+// installed on some platforms are not reported as missing, their markers are
+// evaluated by pip instead. When poetry.lock does not record the groups of the
+// packages, they are worked out from the dependencies in pyProject.
+func wheelhouseRequirements(lock PoetryLock, pyProject PyProject, dir string, groups, extras []string) ([]string, error) {
+	packages := map[string]PoetryLockPackage{}
+	for _, lockPackage := range lock.Packages {
+		packages[normalizeName(lockPackage.Name)] = lockPackage
+	}
+
+	// Lock files written before Poetry 2.1 record no groups, or only whether
+	// a package is in the main group.
+	var derivedGroups map[string][]string
+	if !slices.ContainsFunc(lock.Packages, func(lockPackage PoetryLockPackage) bool { return len(lockPackage.Groups) > 0 }) {
+		derivedGroups = lockedGroups(lock, pyProject.groupRequests())
+	}
+
+	// Optional packages are installed for the selected extras, along with
+	// their optional dependencies.
+	var optional []string
+	for extra, names := range lock.Extras {
+		if !slices.Contains(extras, normalizeName(extra)) {
+			continue
+		}
+
+		for _, name := range names {
+			name, _, _ = strings.Cut(name, " ")
+			optional = appendUnique(optional, normalizeName(name))
+		}
+	}
+
+	for i := 0; i < len(optional); i++ {
+		for dependency := range packages[optional[i]].Dependencies {
+			if packages[normalizeName(dependency)].Optional {
+				optional = appendUnique(optional, normalizeName(dependency))
+			}
+		}
+	}
+
+	var (
+		requirements []string
+		missing      []string
+	)
+
+	for _, lockPackage := range lock.Packages {
+		if lockPackage.Optional && !slices.Contains(optional, normalizeName(lockPackage.Name)) {
+			continue
+		}
+
+		packageGroups := lockPackage.PackageGroups()
+		if derivedGroups != nil {
+			packageGroups = derivedGroups[normalizeName(lockPackage.Name)]
+		}
+
+		if (derivedGroups != nil || len(packageGroups) > 0) && !slices.ContainsFunc(packageGroups, func(group string) bool { return slices.Contains(groups, group) }) {
+			continue
+		}
+
+		if lockPackage.Source.Type != "" && lockPackage.Source.Type != "legacy" {
+			return nil, fmt.Errorf("package '%s' from a %s source cannot be installed from the wheelhouse", lockPackage.Name, lockPackage.Source.Type)
+		}
+
+		marker := lockPackage.Marker(groups)
+
+		var (
+			hashes []string
+			found  bool
+		)
+		for _, lockFile := range lockPackage.Files {
+			hashes = append(hashes, fmt.Sprintf("--hash=%s", lockFile.Hash))
+
+			path := filepath.Join(dir, lockFile.File)
+			if _, err := os.Stat(path); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+
+			if err := verifyDistribution(path, lockFile.Hash); err != nil {
+				return nil, err
+			}
+			found = true
+		}
+
+		if !found && marker == "" {
+			missing = append(missing, fmt.Sprintf("%s==%s", lockPackage.Name, lockPackage.Version))
+			continue
+		}
+
+		requirement := fmt.Sprintf("%s==%s", lockPackage.Name, lockPackage.Version)
+		if marker != "" {
+			requirement = fmt.Sprintf("%s ; %s", requirement, marker)
+		}
+		requirements = append(requirements, strings.Join(append([]string{requirement}, hashes...), " "))
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("the wheelhouse '%s' has no distributions for the following locked packages:\n  %s", dir, strings.Join(missing, "\n  "))
+	}
+
+	return requirements, nil
+}
+
+// lockedGroups returns the dependency groups of the locked packages, keyed by
+// normalized name, for a poetry.lock that does not record them. A package
+// belongs to each group from whose direct dependencies, given by requests, it
+// is reached through the dependencies recorded in poetry.lock. The optional
+// dependencies of a package are only followed for the extras asked for.
+func lockedGroups(lock PoetryLock, requests map[string][]packageRequest) map[string][]string {
+	entries := map[string][]PoetryLockPackage{}
+	for _, lockPackage := range lock.Packages {
+		name := normalizeName(lockPackage.Name)
+		entries[name] = append(entries[name], lockPackage)
+	}
+
+	groups := map[string][]string{}
+	for group, roots := range requests {
+		reached := map[string][]string{}
+
+		queue := append([]packageRequest{}, roots...)
+		for len(queue) > 0 {
+			request := queue[0]
+			queue = queue[1:]
+
+			extras, seen := reached[request.Name]
+			added := false
+			for _, extra := range request.Extras {
+				if !slices.Contains(extras, extra) {
+					extras = append(extras, extra)
+					added = true
+				}
+			}
+
+			if seen && !added {
+				continue
+			}
+			reached[request.Name] = extras
+
+			for _, lockPackage := range entries[request.Name] {
+				for name, dependency := range lockPackage.Dependencies {
+					if optionalDependency(dependency) && !requestedByExtras(lockPackage, extras, name) {
+						continue
+					}
+					queue = append(queue, poetryRequest(name, dependency))
+				}
+			}
+		}
+
+		for name := range reached {
+			groups[name] = append(groups[name], group)
+		}
+	}
+
+	return groups
+}
+
+// optionalDependency reports whether a dependency of a poetry.lock entry is
+// only installed for an extra of the package.
+func optionalDependency(dependency interface{}) bool {
+	switch dependency := dependency.(type) {
+	case map[string]interface{}:
+		optional, _ := dependency["optional"].(bool)
+		return optional
+	case []map[string]interface{}:
+		for _, constraint := range dependency {
+			if !optionalDependency(constraint) {
+				return false
+			}
+		}
+		return len(dependency) > 0
+	case []interface{}:
+		for _, constraint := range dependency {
+			if !optionalDependency(constraint) {
+				return false
+			}
+		}
+		return len(dependency) > 0
+	}
+
+	return false
+}
+
+// requestedByExtras reports whether one of the given extras of a locked
+// package installs its dependency with the given name.
+func requestedByExtras(lockPackage PoetryLockPackage, extras []string, name string) bool {
+	for extra, requirements := range lockPackage.Extras {
+		if !slices.Contains(extras, normalizeName(extra)) {
+			continue
+		}
+
+		for _, requirement := range requirements {
+			if requirementRequest(requirement).Name == normalizeName(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifyDistribution checks the distribution file at path against a hash from
+// poetry.lock, in the form 'sha256:<hex digest>'.
+func verifyDistribution(path, hash string) error {
+	algorithm, expected, _ := strings.Cut(hash, ":")
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported hash algorithm '%s' for '%s' in poetry.lock", algorithm, filepath.Base(path))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != expected {
+		return fmt.Errorf("distribution '%s' does not match poetry.lock: expected sha256 '%s', got '%s'", filepath.Base(path), expected, actual)
+	}
+
+	return nil
+}